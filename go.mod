@@ -3,13 +3,16 @@ module github.com/kenmoini/roadrunner
 go 1.19
 
 require (
-	github.com/mholt/acmez v1.0.4 // indirect
+	github.com/mholt/acmez v1.0.4
+	go.uber.org/zap v1.24.0
+	golang.org/x/exp v0.0.0-20221217163422-3c43f8badb15
+	golang.org/x/net v0.0.0-20220630215102-69896b714898
+	golang.org/x/sys v0.3.0
+	gopkg.in/yaml.v2 v2.4.0
+)
+
+require (
 	go.uber.org/atomic v1.7.0 // indirect
 	go.uber.org/multierr v1.6.0 // indirect
-	go.uber.org/zap v1.24.0 // indirect
-	golang.org/x/exp v0.0.0-20221217163422-3c43f8badb15 // indirect
-	golang.org/x/net v0.0.0-20220630215102-69896b714898 // indirect
-	golang.org/x/sys v0.3.0 // indirect
 	golang.org/x/text v0.3.7 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
@@ -5,7 +5,13 @@ func DisplayHelp() {
 	const message = `Roadrunner is a simple tool to help you manage your certificates and keys.
 
 Usage:
-  roadrunner -config <path to config file>`
+  roadrunner -config <path to config file>
+
+Exit codes in CLI mode:
+  0  nothing to do, all certificates are current
+  1  fatal error (invalid configuration, working directory locked)
+  2  one or more certificates failed
+  3  one or more certificates were issued, renewed or re-deployed`
 
 	// Print the message
 	println(message)
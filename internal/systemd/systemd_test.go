@@ -0,0 +1,217 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listenNotifySocket binds a fake notify socket and points NOTIFY_SOCKET at it
+func listenNotifySocket(t *testing.T) *net.UnixConn {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("binding the notify socket: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	return conn
+}
+
+// readNotification reads the next state sent to the fake notify socket
+func readNotification(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("reading the notify socket: %v", err)
+	}
+	return string(buf[:n])
+}
+
+func TestNotify(t *testing.T) {
+	conn := listenNotifySocket(t)
+
+	for _, state := range []string{"READY=1", "STATUS=Checking certificates...", "WATCHDOG=1"} {
+		sent, err := Notify(state)
+		if err != nil || !sent {
+			t.Fatalf("Notify(%q) = %v, %v, want true, nil", state, sent, err)
+		}
+		if got := readNotification(t, conn); got != state {
+			t.Errorf("notify socket got %q, want %q", got, state)
+		}
+	}
+}
+
+func TestNotifyWithoutSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	sent, err := Notify("READY=1")
+	if sent || err != nil {
+		t.Errorf("Notify without NOTIFY_SOCKET = %v, %v, want false, nil", sent, err)
+	}
+}
+
+func TestNotifyAbstractSocket(t *testing.T) {
+	name := "roadrunner-test-" + strconv.Itoa(os.Getpid())
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: "\x00" + name, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("abstract sockets are not supported: %v", err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", "@"+name)
+
+	if _, err := Notify("READY=1"); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if got := readNotification(t, conn); got != "READY=1" {
+		t.Errorf("notify socket got %q, want READY=1", got)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	tests := []struct {
+		name string
+		usec string
+		pid  string
+		want time.Duration
+	}{
+		{"disabled", "", "", 0},
+		{"invalid", "soon", "", 0},
+		{"enabled", "30000000", "", 15 * time.Second},
+		{"this process", "30000000", strconv.Itoa(os.Getpid()), 15 * time.Second},
+		{"another process", "30000000", "1", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("WATCHDOG_USEC", tt.usec)
+			t.Setenv("WATCHDOG_PID", tt.pid)
+			if got := WatchdogInterval(); got != tt.want {
+				t.Errorf("WatchdogInterval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWatchdogNotify(t *testing.T) {
+	conn := listenNotifySocket(t)
+	t.Setenv("WATCHDOG_USEC", "2000000")
+	t.Setenv("WATCHDOG_PID", "")
+
+	if WatchdogInterval() != time.Second {
+		t.Fatalf("WatchdogInterval() = %v, want 1s", WatchdogInterval())
+	}
+	if _, err := Notify("WATCHDOG=1"); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if got := readNotification(t, conn); got != "WATCHDOG=1" {
+		t.Errorf("notify socket got %q, want WATCHDOG=1", got)
+	}
+}
+
+// TestListenersHelper runs in the child process started by runWithListeners, where the
+// sockets are fd 3 onwards, and prints the name and address of every listener it was passed
+func TestListenersHelper(t *testing.T) {
+	if os.Getenv("ROADRUNNER_LISTENERS_HELPER") != "1" {
+		t.Skip("only runs as a child process")
+	}
+	// The PID isn't known until the child starts, so it is filled in here
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+
+	listeners, err := Listeners()
+	if err != nil {
+		t.Fatalf("Listeners: %v", err)
+	}
+	for name, listener := range listeners {
+		os.Stdout.WriteString("LISTENER " + name + " " + listener.Addr().String() + "\n")
+	}
+	for _, env := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		if value, ok := os.LookupEnv(env); ok {
+			t.Errorf("%v is still set to %q", env, value)
+		}
+	}
+}
+
+// runWithListeners passes the listeners to a child test process like systemd socket activation does
+// and returns the listener addresses it found keyed by name
+func runWithListeners(t *testing.T, names string, listeners ...*net.TCPListener) map[string]string {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestListenersHelper$", "-test.v")
+	cmd.Env = append(os.Environ(), "ROADRUNNER_LISTENERS_HELPER=1", "LISTEN_FDS="+strconv.Itoa(len(listeners)))
+	if names != "" {
+		cmd.Env = append(cmd.Env, "LISTEN_FDNAMES="+names)
+	}
+	for _, listener := range listeners {
+		file, err := listener.File()
+		if err != nil {
+			t.Fatalf("getting the listener file: %v", err)
+		}
+		defer file.Close()
+		cmd.ExtraFiles = append(cmd.ExtraFiles, file)
+	}
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("child process failed: %v\n%s", err, output)
+	}
+
+	found := map[string]string{}
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 3 && fields[0] == "LISTENER" {
+			found[fields[1]] = fields[2]
+		}
+	}
+	return found
+}
+
+// listenTCP opens a listener on a random local port
+func listenTCP(t *testing.T) *net.TCPListener {
+	t.Helper()
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	return listener
+}
+
+func TestListeners(t *testing.T) {
+	metrics, http01 := listenTCP(t), listenTCP(t)
+
+	found := runWithListeners(t, "metrics:http-01", metrics, http01)
+	want := map[string]string{"metrics": metrics.Addr().String(), "http-01": http01.Addr().String()}
+	if len(found) != len(want) {
+		t.Fatalf("found listeners %v, want %v", found, want)
+	}
+	for name, addr := range want {
+		if found[name] != addr {
+			t.Errorf("listener %v = %v, want %v", name, found[name], addr)
+		}
+	}
+}
+
+func TestListenersUnnamed(t *testing.T) {
+	listener := listenTCP(t)
+
+	found := runWithListeners(t, "", listener)
+	if found["unknown"] != listener.Addr().String() || len(found) != 1 {
+		t.Errorf("found listeners %v, want unknown = %v", found, listener.Addr())
+	}
+}
+
+func TestListenersWrongPID(t *testing.T) {
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+
+	listeners, err := Listeners()
+	if err != nil || len(listeners) != 0 {
+		t.Errorf("Listeners for another process = %v, %v, want none", listeners, err)
+	}
+}
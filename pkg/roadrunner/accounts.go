@@ -0,0 +1,64 @@
+package roadrunner
+
+import (
+	"context"
+	"sync"
+
+	"github.com/mholt/acmez"
+	"github.com/mholt/acmez/acme"
+	"go.uber.org/zap"
+)
+
+// AccountRegistry shares ACME accounts between the certificates processed in a run,
+// so an account used by many certificates is only created once even when they are processed in parallel
+type AccountRegistry struct {
+	mu       sync.Mutex
+	accounts map[string]*registeredAccount
+}
+
+// registeredAccount is an account in the registry, its lock is held while the account is created
+type registeredAccount struct {
+	mu      sync.Mutex
+	account *acme.Account
+}
+
+// NewAccountRegistry creates an empty AccountRegistry
+func NewAccountRegistry() *AccountRegistry {
+	return &AccountRegistry{accounts: map[string]*registeredAccount{}}
+}
+
+// Account returns the account for the email on the client's ACME directory, creating it on first use
+// Failures aren't remembered so the next certificate tries again
+func (r *AccountRegistry) Account(ctx context.Context, email string, client acmez.Client, logger *zap.Logger) (acme.Account, error) {
+	key := client.Client.Directory + " " + email
+
+	r.mu.Lock()
+	entry, ok := r.accounts[key]
+	if !ok {
+		entry = &registeredAccount{}
+		r.accounts[key] = entry
+	}
+	r.mu.Unlock()
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if entry.account != nil {
+		return *entry.account, nil
+	}
+
+	account, err := CreateACMEClientAccount(ctx, email, client, logger)
+	if err != nil {
+		return acme.Account{}, err
+	}
+	if account.Status == acme.StatusValid {
+		entry.account = &account
+	}
+	return account, nil
+}
+
+// Reset forgets the accounts so the next run checks them with the CA again
+func (r *AccountRegistry) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.accounts = map[string]*registeredAccount{}
+}
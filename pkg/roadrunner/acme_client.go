@@ -5,11 +5,12 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
-	"crypto/tls"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
+	"sync"
 
 	"github.com/kenmoini/roadrunner/internal/helpers"
 	"github.com/kenmoini/roadrunner/internal/logging"
@@ -32,16 +33,27 @@ type OrderResponse struct {
 
 // ConnectionInfo is the information needed to connect to an ACME server
 type ConnectionInfo struct {
-	DirectoryURL  string                  `yaml:"directory_url"`
-	SkipTLSVerify bool                    `yaml:"skip_tls_verify,omitempty"`
-	Solvers       map[string]acmez.Solver `yaml:"solvers,omitempty"`
+	// IssuerName is the name of the Issuer the connection is for, used to label metrics
+	IssuerName    string `yaml:"issuer_name,omitempty"`
+	DirectoryURL  string `yaml:"directory_url"`
+	SkipTLSVerify bool   `yaml:"skip_tls_verify,omitempty"`
+	// CAFile is an optional CA bundle that is trusted in addition to the system roots
+	CAFile string `yaml:"ca_file,omitempty"`
+	// ClientCertFile and ClientKeyFile are an optional certificate pair used for mTLS
+	ClientCertFile string `yaml:"client_cert_file,omitempty"`
+	ClientKeyFile  string `yaml:"client_key_file,omitempty"`
+	// HTTPProxy, HTTPSProxy and NoProxy control the outbound proxy for the endpoint
+	HTTPProxy  string                  `yaml:"http_proxy,omitempty"`
+	HTTPSProxy string                  `yaml:"https_proxy,omitempty"`
+	NoProxy    []string                `yaml:"no_proxy,omitempty"`
+	Solvers    map[string]acmez.Solver `yaml:"solvers,omitempty"`
 }
 
 // mySolver is a no-op acmez.Solver for example purposes only.
 type mySolver struct{}
 
 // CreateACMEClient creates a new ACME client
-func CreateACMEClient(cInfo ConnectionInfo, solvers map[string]acmez.Solver, logger *zap.Logger) acmez.Client {
+func CreateACMEClient(cInfo ConnectionInfo, solvers map[string]acmez.Solver, logger *zap.Logger) (acmez.Client, error) {
 
 	// A high-level client embeds a low-level client and makes
 	// the ACME flow much easier, but with less flexibility
@@ -59,24 +71,34 @@ func CreateACMEClient(cInfo ConnectionInfo, solvers map[string]acmez.Solver, log
 	// solver for the dns-01 challenge in CertMagic:
 	// https://pkg.go.dev/github.com/caddyserver/certmagic#DNS01Solver
 
+	// Build the proxy and TLS aware transport for the ACME endpoint
+	transport, err := NewHTTPTransport(cInfo)
+	if err != nil {
+		return acmez.Client{}, err
+	}
+
 	client := acmez.Client{
 		Client: &acme.Client{
 			Directory: cInfo.DirectoryURL,
 			HTTPClient: &http.Client{
-				Transport: &http.Transport{
-					TLSClientConfig: &tls.Config{
-						InsecureSkipVerify: cInfo.SkipTLSVerify, // REMOVE THIS FOR PRODUCTION USE!
-					},
+				Transport: &instrumentedTransport{
+					issuer:    cInfo.IssuerName,
+					transport: transport,
+					metrics:   RoadrunnerMetrics,
 				},
 			},
 			Logger: logger,
 		},
+		ChallengeSolvers: solvers,
 	}
 
 	// Return the client
-	return client
+	return client, nil
 }
 
+// accountKeyFileMu stops parallel certificates from generating the same account key file twice
+var accountKeyFileMu sync.Mutex
+
 // CreateACMEClientAccountKeyFile creates a new ACME client account key file if needed or returns it if it already exists
 // The account key files will be found in the working_directory/.acme/keys/<endpoint-server-hostname>/<emailAT>.key path.
 func CreateACMEClientAccountKeyFile(email string, cInfo ConnectionInfo) (*ecdsa.PrivateKey, error) {
@@ -85,6 +107,9 @@ func CreateACMEClientAccountKeyFile(email string, cInfo ConnectionInfo) (*ecdsa.
 	endpointServerHostnamePath := helpers.AppendSlash(RunningConfig.Roadrunner.Config.WorkingDir) + ".acme/keys/" + endpointServerHostname
 	accountKeyFilePath := endpointServerHostnamePath + "/" + sanitizedEmail + ".key"
 
+	accountKeyFileMu.Lock()
+	defer accountKeyFileMu.Unlock()
+
 	// Check to see if the endpoint server hostname path exists
 	pathCheck, err := DirectoryExists(endpointServerHostnamePath)
 	if err != nil {
@@ -93,7 +118,9 @@ func CreateACMEClientAccountKeyFile(email string, cInfo ConnectionInfo) (*ecdsa.
 
 	// If the endpoint server hostname path does not exist, create it
 	if !pathCheck {
-		CreateDirectory(endpointServerHostnamePath)
+		if err := os.MkdirAll(endpointServerHostnamePath, 0755); err != nil {
+			return nil, err
+		}
 	}
 
 	// Check to see if the key file exists
@@ -120,7 +147,10 @@ func CreateACMEClientAccountKeyFile(email string, cInfo ConnectionInfo) (*ecdsa.
 
 	} else {
 		// Read in the key file now
-		readKey := DecodeECDSAPrivateKeyPEM(accountKeyFilePath)
+		readKey, err := DecodeECDSAPrivateKeyPEM(accountKeyFilePath)
+		if err != nil {
+			return nil, fmt.Errorf("loading the account key file %v: %v", accountKeyFilePath, err)
+		}
 		logging.LogStdOutInfo("Loaded key file: " + accountKeyFilePath)
 
 		return readKey, nil
@@ -133,10 +163,7 @@ func CreateACMEClientAccountKeyFile(email string, cInfo ConnectionInfo) (*ecdsa.
 // An account is a combination of email address and private key that is used to identify you to the ACME CA.
 // You only need to create an account once, and then you can use it to get as many certificates as you want.
 // The files are stored in the working_directory/.acme/accounts/<endpoint-server-hostname>/<email>/ directories.
-func CreateACMEClientAccount(email string, client acmez.Client, logger *zap.Logger) (acme.Account, error) {
-	// A context allows us to cancel long-running ops
-	ctx := context.Background()
-
+func CreateACMEClientAccount(ctx context.Context, email string, client acmez.Client, logger *zap.Logger) (acme.Account, error) {
 	// Before you can get a cert, you'll need an account registered with
 	// the ACME CA; it needs a private key which should obviously be
 	// different from any key used for certificates!
@@ -162,7 +189,7 @@ func CreateACMEClientAccount(email string, client acmez.Client, logger *zap.Logg
 	// you can reuse it later!
 	account, err = client.NewAccount(ctx, account)
 	if err != nil {
-		return acme.Account{}, fmt.Errorf("new account error: %v", err)
+		return acme.Account{}, fmt.Errorf("new account error: %w", err)
 	}
 
 	// Return the account
@@ -0,0 +1,295 @@
+package roadrunner
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mholt/acmez"
+	"github.com/mholt/acmez/acme"
+)
+
+// fakeACME is a stand-in ACME server covering the directory, nonces, new orders and renewal information
+// It checks every JWS it receives against the account key, nonce and URL the request should have used
+type fakeACME struct {
+	t      *testing.T
+	server *httptest.Server
+
+	// accountKey and accountURL are the account expected to sign requests
+	accountKey crypto.PublicKey
+	accountURL string
+	// noRenewalInfo leaves renewalInfo out of the directory
+	noRenewalInfo bool
+	// badNonces is how many new order requests are answered with a badNonce problem first
+	badNonces int
+	// rejectReplaces is the problem type new orders naming a replaced certificate are rejected with
+	rejectReplaces string
+	// renewalWindows are the windows served by certID, and renewalRetryAfter the Retry-After sent with them
+	renewalWindows    map[string]RenewalWindow
+	renewalRetryAfter string
+
+	mu     sync.Mutex
+	nonces map[string]bool
+	issued int
+	// orders are the payloads of the new order requests that were accepted or rejected, in order
+	orders []map[string]interface{}
+	// renewalInfoRequests are the certIDs renewal information was requested for
+	renewalInfoRequests []string
+}
+
+// newFakeACME starts a stand-in ACME server for the account key
+func newFakeACME(t *testing.T, accountKey crypto.Signer) *fakeACME {
+	t.Helper()
+	f := &fakeACME{
+		t:              t,
+		accountKey:     accountKey.Public(),
+		renewalWindows: map[string]RenewalWindow{},
+		nonces:         map[string]bool{},
+	}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	f.accountURL = f.server.URL + "/account/1"
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// directoryURL is the URL of the directory of the server
+func (f *fakeACME) directoryURL() string {
+	return f.server.URL + "/directory"
+}
+
+// account is the valid account the server expects requests from
+func (f *fakeACME) account(key crypto.Signer) acme.Account {
+	return acme.Account{Status: acme.StatusValid, Location: f.accountURL, PrivateKey: key}
+}
+
+// client is an ACME client for the server built the same way roadrunner builds them
+func (f *fakeACME) client() acmez.Client {
+	f.t.Helper()
+	client, err := CreateACMEClient(ConnectionInfo{IssuerName: "fake", DirectoryURL: f.directoryURL()}, nil, nil)
+	if err != nil {
+		f.t.Fatalf("CreateACMEClient: %v", err)
+	}
+	return client
+}
+
+// newNonce issues a nonce that can be used once
+func (f *fakeACME) newNonce() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.issued++
+	nonce := "nonce-" + strconv.Itoa(f.issued)
+	f.nonces[nonce] = true
+	return nonce
+}
+
+// problem responds with an ACME problem document
+func (f *fakeACME) problem(w http.ResponseWriter, status int, problemType string, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"type": problemType, "detail": detail, "status": status})
+}
+
+func (f *fakeACME) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", f.newNonce())
+	w.Header().Set("Content-Type", "application/json")
+	base := f.server.URL
+
+	switch {
+	case r.URL.Path == "/directory":
+		directory := map[string]string{
+			"newNonce":   base + "/new-nonce",
+			"newAccount": base + "/new-account",
+			"newOrder":   base + "/new-order",
+		}
+		if !f.noRenewalInfo {
+			directory["renewalInfo"] = base + "/renewal-info"
+		}
+		json.NewEncoder(w).Encode(directory)
+
+	case r.URL.Path == "/new-nonce":
+		w.WriteHeader(http.StatusOK)
+
+	case r.URL.Path == "/new-order" && r.Method == http.MethodPost:
+		payload, ok := f.verifyJWS(w, r)
+		if !ok {
+			return
+		}
+
+		f.mu.Lock()
+		badNonce := f.badNonces > 0
+		if badNonce {
+			f.badNonces--
+		} else {
+			f.orders = append(f.orders, payload)
+		}
+		f.mu.Unlock()
+		if badNonce {
+			f.problem(w, http.StatusBadRequest, acme.ProblemTypeBadNonce, "stale nonce")
+			return
+		}
+		if _, ok := payload["replaces"]; ok && f.rejectReplaces != "" {
+			f.problem(w, http.StatusConflict, f.rejectReplaces, "rejected replaces")
+			return
+		}
+
+		w.Header().Set("Location", base+"/order/1")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":         acme.StatusPending,
+			"identifiers":    payload["identifiers"],
+			"authorizations": []string{base + "/authz/1"},
+			"finalize":       base + "/order/1/finalize",
+		})
+
+	case strings.HasPrefix(r.URL.Path, "/renewal-info/") && r.Method == http.MethodGet:
+		certID := strings.TrimPrefix(r.URL.Path, "/renewal-info/")
+		f.mu.Lock()
+		f.renewalInfoRequests = append(f.renewalInfoRequests, certID)
+		window, ok := f.renewalWindows[certID]
+		f.mu.Unlock()
+		if !ok {
+			f.problem(w, http.StatusNotFound, acme.ProblemTypeMalformed, "unknown certificate "+certID)
+			return
+		}
+		if f.renewalRetryAfter != "" {
+			w.Header().Set("Retry-After", f.renewalRetryAfter)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"suggestedWindow": window})
+
+	default:
+		f.problem(w, http.StatusNotFound, acme.ProblemTypeMalformed, "no such endpoint "+r.Method+" "+r.URL.Path)
+	}
+}
+
+// verifyJWS checks the flattened JWS of the request was signed by the account for this URL with a
+// nonce the server issued and that hasn't been used, and returns the decoded payload
+func (f *fakeACME) verifyJWS(w http.ResponseWriter, r *http.Request) (map[string]interface{}, bool) {
+	fail := func(format string, args ...interface{}) (map[string]interface{}, bool) {
+		f.t.Errorf("%v %v: "+format, append([]interface{}{r.Method, r.URL.Path}, args...)...)
+		f.problem(w, http.StatusBadRequest, acme.ProblemTypeMalformed, fmt.Sprintf(format, args...))
+		return nil, false
+	}
+
+	if contentType := r.Header.Get("Content-Type"); contentType != "application/jose+json" {
+		return fail("content type is %q", contentType)
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return fail("reading the body: %v", err)
+	}
+	jws := map[string]string{}
+	if err := json.Unmarshal(body, &jws); err != nil {
+		return fail("decoding the JWS: %v", err)
+	}
+
+	protectedJSON, err := base64.RawURLEncoding.DecodeString(jws["protected"])
+	if err != nil {
+		return fail("decoding the protected header: %v", err)
+	}
+	protected := map[string]interface{}{}
+	if err := json.Unmarshal(protectedJSON, &protected); err != nil {
+		return fail("decoding the protected header: %v", err)
+	}
+	if protected["kid"] != f.accountURL {
+		return fail("kid is %v, want %v", protected["kid"], f.accountURL)
+	}
+	if _, ok := protected["jwk"]; ok {
+		return fail("jwk is set alongside kid")
+	}
+	if want := f.server.URL + r.URL.Path; protected["url"] != want {
+		return fail("url is %v, want %v", protected["url"], want)
+	}
+
+	nonce, _ := protected["nonce"].(string)
+	f.mu.Lock()
+	fresh := f.nonces[nonce]
+	delete(f.nonces, nonce)
+	f.mu.Unlock()
+	if !fresh {
+		return fail("nonce %q was not issued or was already used", nonce)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(jws["signature"])
+	if err != nil {
+		return fail("decoding the signature: %v", err)
+	}
+	if err := verifyJWSSignature(f.accountKey, protected["alg"], jws["protected"]+"."+jws["payload"], signature); err != nil {
+		return fail("%v", err)
+	}
+
+	payloadJSON, err := base64.RawURLEncoding.DecodeString(jws["payload"])
+	if err != nil {
+		return fail("decoding the payload: %v", err)
+	}
+	payload := map[string]interface{}{}
+	if err := json.Unmarshal(payloadJSON, &payload); err != nil {
+		return fail("decoding the payload: %v", err)
+	}
+	return payload, true
+}
+
+// verifyJWSSignature checks a JWS signature over the signing input with the algorithm named in the header
+func verifyJWSSignature(key crypto.PublicKey, alg interface{}, signingInput string, signature []byte) error {
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if alg != "RS256" {
+			return fmt.Errorf("alg is %v for an RSA key", alg)
+		}
+		digest := sha256.Sum256([]byte(signingInput))
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature)
+	case *ecdsa.PublicKey:
+		var digest []byte
+		switch pub.Params().Name {
+		case "P-256":
+			sum := sha256.Sum256([]byte(signingInput))
+			digest = sum[:]
+		case "P-384":
+			sum := sha512.Sum384([]byte(signingInput))
+			digest = sum[:]
+		case "P-521":
+			sum := sha512.Sum512([]byte(signingInput))
+			digest = sum[:]
+		}
+		want := map[string]string{"P-256": "ES256", "P-384": "ES384", "P-521": "ES512"}[pub.Params().Name]
+		if alg != want {
+			return fmt.Errorf("alg is %v for a %v key, want %v", alg, pub.Params().Name, want)
+		}
+		size := (pub.Params().BitSize + 7) / 8
+		if len(signature) != size*2 {
+			return fmt.Errorf("signature is %d bytes, want %d raw R and S bytes", len(signature), size*2)
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported key %T", key)
+}
+
+// orderCount is how many new order requests the server has recorded
+func (f *fakeACME) orderCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.orders)
+}
+
+// timeOf truncates a time to seconds, as it round trips through JSON and YAML
+func timeOf(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Second)
+}
@@ -0,0 +1,268 @@
+package roadrunner
+
+import (
+	"context"
+	"crypto"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kenmoini/roadrunner/internal/logging"
+	"github.com/mholt/acmez/acme"
+)
+
+// RevokeRequest is the optional body of a revoke request
+type RevokeRequest struct {
+	// Reason is the RFC 5280 revocation reason code, defaults to unspecified
+	Reason int `json:"reason"`
+}
+
+// registerAdminHandlers adds the authenticated admin endpoints to the API
+// Jobs are run with the daemon context so they outlive the request that started them
+func (d *Daemon) registerAdminHandlers(ctx context.Context, mux *http.ServeMux) {
+	mux.Handle("/v1/certificates/", logRequests(d.requireAdmin(d.handleCertificateAction(ctx))))
+	mux.Handle("/v1/reload", logRequests(d.requireAdmin(d.handleReload(ctx))))
+	mux.Handle("/v1/jobs", logRequests(d.requireAdmin(http.HandlerFunc(d.handleJobs))))
+	mux.Handle("/v1/jobs/", logRequests(d.requireAdmin(http.HandlerFunc(d.handleJob))))
+}
+
+// requireAdmin only lets requests with the admin token or a verified client certificate through
+func (d *Daemon) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		appConfig := d.Config().Roadrunner.Config
+		if appConfig.APIAdminToken == "" && appConfig.APIClientCAFile == "" {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "the admin API is not enabled"})
+			return
+		}
+
+		// Client certificates are verified against api_client_ca_file during the handshake
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if appConfig.APIAdminToken != "" {
+			authorization := r.Header.Get("Authorization")
+			token := strings.TrimPrefix(authorization, "Bearer ")
+			if token != authorization && subtle.ConstantTimeCompare([]byte(token), []byte(appConfig.APIAdminToken)) == 1 {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		logging.LogNetworkRequestStdOut("Rejected unauthorized admin request "+r.Method+" "+r.URL.Path, r)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="roadrunner"`)
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	})
+}
+
+// handleCertificateAction starts a renew, reissue or revoke job for a certificate
+// The path is /v1/certificates/{name}/{action}
+func (d *Daemon) handleCertificateAction(ctx context.Context) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+
+		name, action, found := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v1/certificates/"), "/")
+		if !found || name == "" {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+			return
+		}
+		if _, ok := d.Config().FindCertificate(name); !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("certificate [%v] is not configured", name)})
+			return
+		}
+
+		var work JobFunc
+		switch action {
+		case "renew":
+			work = d.renewJob(name, true)
+		case "reissue":
+			work = d.renewJob(name, false)
+		case "revoke":
+			request := RevokeRequest{Reason: acme.ReasonUnspecified}
+			if r.ContentLength != 0 {
+				if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid request body: %v", err)})
+					return
+				}
+			}
+			if request.Reason < 0 || request.Reason > 10 || request.Reason == 7 {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid revocation reason %d", request.Reason)})
+				return
+			}
+			work = d.revokeJob(name, request.Reason)
+		default:
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+			return
+		}
+
+		d.submitJob(ctx, w, action, name, work)
+	})
+}
+
+// handleReload starts a job that re-reads the configuration file through the scheduler, the same way SIGHUP does,
+// so new or changed certificates are checked straight away
+func (d *Daemon) handleReload(ctx context.Context) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+
+		d.submitJob(ctx, w, "reload", "", func(ctx context.Context) (CertificateResult, error) {
+			return "", d.ReloadAndWait(ctx)
+		})
+	})
+}
+
+// handleJobs lists the retained jobs, newest first
+func (d *Daemon) handleJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	writeJSON(w, http.StatusOK, d.jobs.List())
+}
+
+// handleJob reports the progress and result of a single job at /v1/jobs/{id}
+func (d *Daemon) handleJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	job, ok := d.jobs.Get(strings.TrimPrefix(r.URL.Path, "/v1/jobs/"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "job not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// submitJob queues the work and responds with the job to poll
+func (d *Daemon) submitJob(ctx context.Context, w http.ResponseWriter, jobType string, certName string, work JobFunc) {
+	job, err := d.jobs.Submit(ctx, jobType, certName, work)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	w.Header().Set("Location", "/v1/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// renewJob renews a certificate now, keeping the live key when reuseKey is set
+func (d *Daemon) renewJob(name string, reuseKey bool) JobFunc {
+	return func(ctx context.Context) (CertificateResult, error) {
+		unlock, err := CertLocks.Lock(ctx, name)
+		if err != nil {
+			return ResultFailed, err
+		}
+		defer unlock()
+
+		config := d.Config()
+		cert, ok := config.FindCertificate(name)
+		if !ok {
+			return ResultFailed, fmt.Errorf("certificate [%v] is no longer configured", name)
+		}
+		if cert.SaveType == "" {
+			cert.SaveType = DefaultSaveType
+		}
+		logPrefix := fmt.Sprintf("[admin - %v]", name)
+
+		// Forcing a renewal overrides the backoff, but not a CA rate limit
+		backoff, err := ReadBackoffState(config.Roadrunner.Config.WorkingDir, name)
+		if err != nil {
+			return ResultFailed, fmt.Errorf("reading the backoff state: %v", err)
+		}
+		if backoff.RateLimited && backoff.Waiting(time.Now()) {
+			return ResultDeferred, fmt.Errorf("rate limited by the CA until %v", backoff.NextAttempt.Format(time.RFC3339))
+		}
+
+		var certKey crypto.Signer
+		if reuseKey {
+			certKey, err = LoadLivePrivateKey(NewLiveCertificatePaths(config.Roadrunner.Config.WorkingDir, name))
+			if err != nil {
+				return ResultFailed, fmt.Errorf("loading the live certificate key: %v", err)
+			}
+			if certKey == nil {
+				logging.LogStdOutInfo(logPrefix + " No live certificate key to reuse, generating a new one...")
+			} else if keyType, keySize := KeySpec(cert.RequestOptions); !keySpecMatches(certKey, keyType, keySize) {
+				logging.LogStdOutInfo(logPrefix + " Live certificate key no longer matches the configured key, generating a new one...")
+				certKey = nil
+			}
+		}
+
+		result, err := config.RenewCertificate(ctx, logPrefix, cert, certKey, d.logger)
+		d.recordResult(CertificateRunResult{Name: name, Result: result, Error: err})
+		config.Notify(ctx, config.CertificateEvents(cert, result, err))
+		return result, err
+	}
+}
+
+// revokeJob revokes the live certificate so the next check replaces it
+func (d *Daemon) revokeJob(name string, reason int) JobFunc {
+	return func(ctx context.Context) (CertificateResult, error) {
+		unlock, err := CertLocks.Lock(ctx, name)
+		if err != nil {
+			return "", err
+		}
+		defer unlock()
+
+		config := d.Config()
+		cert, ok := config.FindCertificate(name)
+		if !ok {
+			return "", fmt.Errorf("certificate [%v] is no longer configured", name)
+		}
+		return "", config.RevokeCertificate(ctx, fmt.Sprintf("[admin - %v]", name), cert, reason, d.logger)
+	}
+}
+
+// FindCertificate returns the configured certificate with the name
+func (config Config) FindCertificate(name string) (Certificate, bool) {
+	for _, cert := range config.Roadrunner.Certificates {
+		if cert.ID() == name {
+			return cert, true
+		}
+	}
+	return Certificate{}, false
+}
+
+// NewAPITLSConfig returns the TLS configuration for the API, or nil when it is served over plain HTTP
+// Client certificates are requested but only required by the admin endpoints
+func NewAPITLSConfig(appConfig AppConfig) (*tls.Config, error) {
+	if appConfig.APITLSCertFile == "" {
+		return nil, nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(appConfig.APITLSCertFile, appConfig.APITLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading the API TLS certificate: %v", err)
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{certificate},
+	}
+
+	if appConfig.APIClientCAFile != "" {
+		caBytes, err := ReadFileToBytes(appConfig.APIClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading the API client CA: %v", err)
+		}
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caBytes) {
+			return nil, fmt.Errorf("no certificates found in the API client CA [%v]", appConfig.APIClientCAFile)
+		}
+		tlsConfig.ClientCAs = clientCAs
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,196 @@
+package roadrunner
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kenmoini/roadrunner/internal/logging"
+)
+
+// CertificateStatus is the status of a configured certificate as reported by the API
+type CertificateStatus struct {
+	// Name is the name of the certificate
+	Name string `json:"name"`
+	// Domains are the configured domains
+	Domains []string `json:"domains"`
+	// Issuers are the configured issuers in order of preference
+	Issuers []string `json:"issuers"`
+	// Issuer is the issuer that produced the live certificate
+	Issuer string `json:"issuer,omitempty"`
+	// NotAfter is when the live certificate expires
+	NotAfter *time.Time `json:"not_after,omitempty"`
+	// LastResult is the outcome of the last check
+	LastResult CertificateResult `json:"last_result,omitempty"`
+	// LastError is the error from the last check, if any
+	LastError string `json:"last_error,omitempty"`
+	// LastChecked is when the certificate was last checked
+	LastChecked *time.Time `json:"last_checked,omitempty"`
+	// NextCheck is when the certificate is next scheduled to be checked
+	NextCheck *time.Time `json:"next_check,omitempty"`
+	// Failures is the number of consecutive failed attempts
+	Failures int `json:"failures,omitempty"`
+	// RetryAt is when a backing off certificate may next be attempted
+	RetryAt *time.Time `json:"retry_at,omitempty"`
+	// RateLimited is set when the CA rate limited the last attempt
+	RateLimited bool `json:"rate_limited,omitempty"`
+}
+
+// NewAPIHandler returns the status API for the daemon along with the authenticated admin endpoints
+func (d *Daemon) NewAPIHandler(ctx context.Context) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", d.handleHealthz)
+	mux.HandleFunc("/readyz", d.handleReadyz)
+	mux.Handle("/v1/certificates", logRequests(http.HandlerFunc(d.handleCertificates)))
+	d.registerAdminHandlers(ctx, mux)
+	return mux
+}
+
+// handleHealthz reports that the process is up
+func (d *Daemon) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleReadyz reports ready once the daemon has completed its first certificate check
+func (d *Daemon) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if !d.Ready() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "starting"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+// handleCertificates lists the configured certificates and their status
+func (d *Daemon) handleCertificates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	writeJSON(w, http.StatusOK, d.CertificateStatuses())
+}
+
+// CertificateStatuses assembles the status of every configured certificate
+func (d *Daemon) CertificateStatuses() []CertificateStatus {
+	config := d.Config()
+	basePath := config.Roadrunner.Config.WorkingDir
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	statuses := []CertificateStatus{}
+	for _, cert := range config.Roadrunner.Certificates {
+		name := cert.ID()
+		status := CertificateStatus{
+			Name:    name,
+			Domains: cert.Domains,
+			Issuers: cert.Issuer.Names(),
+		}
+
+		if liveCert, err := LoadLiveCertificate(NewLiveCertificatePaths(basePath, name)); err == nil && liveCert != nil {
+			notAfter := liveCert.NotAfter
+			status.NotAfter = &notAfter
+		}
+		if metadata, err := ReadLiveMetadata(basePath, name); err == nil {
+			status.Issuer = metadata.Issuer
+		}
+		if backoff, err := ReadBackoffState(basePath, name); err == nil && backoff.Failures > 0 {
+			retryAt := backoff.NextAttempt
+			status.Failures = backoff.Failures
+			status.RetryAt = &retryAt
+			status.RateLimited = backoff.RateLimited
+		}
+		if result, ok := d.lastResults[name]; ok {
+			checked := d.lastChecked[name]
+			status.LastResult = result.Result
+			status.LastChecked = &checked
+			if result.Error != nil {
+				status.LastError = result.Error.Error()
+			}
+		}
+		if !d.nextCheck.IsZero() {
+			nextCheck := d.nextCheck
+			status.NextCheck = &nextCheck
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses
+}
+
+// logRequests logs the client and request for each API call
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logging.LogNetworkRequestStdOut(r.Method+" "+r.URL.Path, r)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeJSON writes a JSON response with the status code
+func writeJSON(w http.ResponseWriter, statusCode int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(body)
+}
+
+// StartHTTPServer serves the handler on the address until the context is cancelled
+// The server uses TLS when tlsConfig is not nil
+func StartHTTPServer(ctx context.Context, name string, address string, handler http.Handler, tlsConfig *tls.Config) {
+	server := &http.Server{
+		Addr:              address,
+		Handler:           handler,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	logging.LogStdOutInfo(fmt.Sprintf("Serving the %v on [%v]", name, address))
+	var err error
+	if tlsConfig != nil {
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		logging.LogStdOutWarn(fmt.Sprintf("The %v stopped: %v", name, err))
+	}
+}
+
+// StartDaemonServers starts the optional status API and metrics listeners
+// When both are configured on the same address /metrics is served by the API server
+func (d *Daemon) StartDaemonServers(ctx context.Context, appConfig AppConfig) {
+	apiAddress := strings.TrimSpace(appConfig.APIListen)
+	metricsAddress := strings.TrimSpace(appConfig.MetricsListen)
+
+	if apiAddress != "" {
+		tlsConfig, err := NewAPITLSConfig(appConfig)
+		if err != nil {
+			logging.LogStdOutWarn(fmt.Sprintf("Not serving the status API: %v", err))
+		} else {
+			if tlsConfig == nil && appConfig.APIAdminToken != "" {
+				logging.LogStdOutWarn("The admin API token is sent over plain HTTP, set api_tls_cert_file and api_tls_key_file to protect it")
+			}
+			mux := d.NewAPIHandler(ctx)
+			if metricsAddress == apiAddress {
+				mux.Handle("/metrics", RoadrunnerMetrics)
+				metricsAddress = ""
+			}
+			go StartHTTPServer(ctx, "status API", apiAddress, mux, tlsConfig)
+		}
+	}
+
+	if metricsAddress != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", RoadrunnerMetrics)
+		go StartHTTPServer(ctx, "metrics", metricsAddress, mux, nil)
+	}
+}
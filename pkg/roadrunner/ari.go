@@ -0,0 +1,263 @@
+package roadrunner
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kenmoini/roadrunner/internal/logging"
+	"github.com/mholt/acmez"
+	"go.uber.org/zap"
+	"golang.org/x/exp/slices"
+)
+
+// ACMEDirectory holds the directory endpoints that acmez doesn't expose
+type ACMEDirectory struct {
+	// NewNonce is the endpoint to fetch a fresh anti-replay nonce from
+	NewNonce string `json:"newNonce"`
+	// NewOrder is the endpoint new orders are created at
+	NewOrder string `json:"newOrder"`
+	// RenewalInfo is the RFC 9773 ACME Renewal Information endpoint, empty when the CA doesn't support it
+	RenewalInfo string `json:"renewalInfo,omitempty"`
+}
+
+// RenewalWindow is the period the CA suggests renewing a certificate in
+type RenewalWindow struct {
+	Start time.Time `json:"start" yaml:"start"`
+	End   time.Time `json:"end" yaml:"end"`
+}
+
+// RenewalInfo is the ACME Renewal Information for a certificate
+type RenewalInfo struct {
+	// SuggestedWindow is when the CA would like the certificate renewed
+	SuggestedWindow RenewalWindow `json:"suggestedWindow"`
+	// ExplanationURL points to a page explaining why the window was set, eg for a mass revocation
+	ExplanationURL string `json:"explanationURL,omitempty"`
+	// RetryAfter is when the renewal information should next be polled
+	RetryAfter time.Time `json:"-"`
+}
+
+// FetchACMEDirectory reads the directory of the ACME endpoint
+func FetchACMEDirectory(ctx context.Context, client acmez.Client) (ACMEDirectory, error) {
+	directory := ACMEDirectory{}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, client.Client.Directory, nil)
+	if err != nil {
+		return directory, err
+	}
+	resp, err := acmeHTTPClient(client).Do(req)
+	if err != nil {
+		return directory, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return directory, fmt.Errorf("fetching the ACME directory: HTTP %d", resp.StatusCode)
+	}
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&directory)
+	return directory, err
+}
+
+// ARICertID returns the RFC 9773 unique identifier of a certificate: the base64url encoded
+// Authority Key Identifier and serial number joined with a period
+func ARICertID(cert *x509.Certificate) (string, error) {
+	if len(cert.AuthorityKeyId) == 0 {
+		return "", fmt.Errorf("certificate has no Authority Key Identifier")
+	}
+
+	// The serial is the DER encoded INTEGER value, without the tag and length
+	serialDER, err := asn1.Marshal(cert.SerialNumber)
+	if err != nil {
+		return "", err
+	}
+	var serial asn1.RawValue
+	if _, err := asn1.Unmarshal(serialDER, &serial); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(cert.AuthorityKeyId) + "." + base64.RawURLEncoding.EncodeToString(serial.Bytes), nil
+}
+
+// GetRenewalInfo fetches the ACME Renewal Information for a certificate
+func GetRenewalInfo(ctx context.Context, client acmez.Client, directory ACMEDirectory, cert *x509.Certificate) (RenewalInfo, error) {
+	info := RenewalInfo{}
+	if directory.RenewalInfo == "" {
+		return info, fmt.Errorf("the ACME directory does not advertise renewalInfo")
+	}
+	certID, err := ARICertID(cert)
+	if err != nil {
+		return info, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(directory.RenewalInfo, "/")+"/"+certID, nil)
+	if err != nil {
+		return info, err
+	}
+	resp, err := acmeHTTPClient(client).Do(req)
+	if err != nil {
+		return info, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return info, fmt.Errorf("fetching the renewal information: HTTP %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&info); err != nil {
+		return info, err
+	}
+	if !info.SuggestedWindow.End.After(info.SuggestedWindow.Start) {
+		return info, fmt.Errorf("the suggested renewal window ends before it starts")
+	}
+
+	// Poll again when the CA asks, within sane bounds
+	now := time.Now()
+	retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now)
+	if !ok {
+		retryAfter = now.Add(DefaultARIPollInterval)
+	}
+	if retryAfter.Before(now.Add(MinARIPollInterval)) {
+		retryAfter = now.Add(MinARIPollInterval)
+	}
+	if retryAfter.After(now.Add(MaxARIPollInterval)) {
+		retryAfter = now.Add(MaxARIPollInterval)
+	}
+	info.RetryAfter = retryAfter
+
+	return info, nil
+}
+
+// SelectRenewalTime picks a random time within the suggested window, as RFC 9773 recommends
+// so that a fleet of clients doesn't renew at the same moment
+func (info RenewalInfo) SelectRenewalTime() time.Time {
+	window := info.SuggestedWindow.End.Sub(info.SuggestedWindow.Start)
+	return info.SuggestedWindow.Start.Add(time.Duration(rand.Int63n(int64(window))))
+}
+
+// ShiftedEarlier reports if the window moved to end before the previous one started,
+// which is how a CA signals that it is about to revoke the certificate
+func (window RenewalWindow) ShiftedEarlier(previous RenewalWindow) bool {
+	if previous.Start.IsZero() {
+		return false
+	}
+	return window.End.Before(previous.Start)
+}
+
+// acmeHTTPClient returns the HTTP client the ACME client uses, with its proxy and TLS settings
+func acmeHTTPClient(client acmez.Client) *http.Client {
+	if client.Client.HTTPClient != nil {
+		return client.Client.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// OrderReplacement names the certificate a new order replaces
+type OrderReplacement struct {
+	// Directory is the directory of the issuer of the replaced certificate
+	Directory ACMEDirectory
+	// CertID is the ARI unique identifier of the replaced certificate
+	CertID string
+}
+
+// ARIRenewalTime returns when the live certificate is due for renewal, using the window suggested by the
+// issuer when it supports ACME Renewal Information and renew_days when it doesn't
+// A window that moves sharply earlier signals a pending revocation, so the certificate is renewed immediately
+func (config Config) ARIRenewalTime(ctx context.Context, logPrefix string, cert Certificate, liveCert *x509.Certificate, logger *zap.Logger) time.Time {
+	basePath := config.Roadrunner.Config.WorkingDir
+	certName := cert.ID()
+	renewAt := RenewalTime(liveCert, cert.RenewDays)
+
+	metadata, err := ReadLiveMetadata(basePath, certName)
+	if err != nil {
+		return renewAt
+	}
+	if metadata.RenewAt != nil {
+		renewAt = *metadata.RenewAt
+	}
+
+	// Use the last selection until the CA asks to be polled again
+	now := time.Now()
+	if metadata.RenewalInfoRetryAfter != nil && now.Before(*metadata.RenewalInfoRetryAfter) {
+		return renewAt
+	}
+
+	idx := slices.IndexFunc(config.Roadrunner.Issuers, func(i Issuer) bool { return i.Name == metadata.Issuer })
+	if idx == -1 {
+		return renewAt
+	}
+	client, err := CreateACMEClient(NewConnectionInfo(config.Roadrunner.Config, config.Roadrunner.Issuers[idx]), nil, logger)
+	if err != nil {
+		return renewAt
+	}
+	directory, err := FetchACMEDirectory(ctx, client)
+	if err != nil {
+		logging.LogStdOutWarn(fmt.Sprintf("%v Failed to fetch the ACME directory of issuer [%v]: %v", logPrefix, metadata.Issuer, err))
+		return renewAt
+	}
+	if directory.RenewalInfo == "" {
+		return renewAt
+	}
+
+	info, err := GetRenewalInfo(ctx, client, directory, liveCert)
+	if err != nil {
+		logging.LogStdOutWarn(fmt.Sprintf("%v Failed to fetch the renewal information: %v", logPrefix, err))
+		return renewAt
+	}
+	window := info.SuggestedWindow
+	logging.LogStdOutInfo(fmt.Sprintf("%v Issuer [%v] suggests renewing between %v and %v", logPrefix, metadata.Issuer, window.Start.UTC().Format(time.RFC3339), window.End.UTC().Format(time.RFC3339)))
+	if info.ExplanationURL != "" {
+		logging.LogStdOutInfo(fmt.Sprintf("%v Renewal window explanation: %v", logPrefix, info.ExplanationURL))
+	}
+
+	switch {
+	case metadata.RenewalWindow != nil && window.ShiftedEarlier(*metadata.RenewalWindow):
+		logging.LogStdOutWarn(fmt.Sprintf("%v Renewal window moved sharply earlier, the certificate is likely to be revoked, renewing now", logPrefix))
+		renewAt = now
+	case metadata.RenewAt != nil && !metadata.RenewAt.Before(window.Start) && metadata.RenewAt.Before(window.End):
+		// Keep the earlier selection while it is still inside the window
+	default:
+		renewAt = info.SelectRenewalTime()
+	}
+
+	metadata.RenewalWindow = &window
+	metadata.RenewAt = &renewAt
+	metadata.RenewalInfoRetryAfter = &info.RetryAfter
+	if err := WriteLiveMetadata(basePath, certName, metadata); err != nil {
+		logging.Check(err, logPrefix+" Failed to write the live certificate metadata")
+	}
+
+	return renewAt
+}
+
+// orderReplacement returns the live certificate a new order from the issuer replaces,
+// or nil when there isn't one or the issuer doesn't support ACME Renewal Information
+// The replaces field is only sent to the issuer that produced the live certificate
+func (config Config) orderReplacement(ctx context.Context, cert Certificate, issuer Issuer, client acmez.Client) *OrderReplacement {
+	basePath := config.Roadrunner.Config.WorkingDir
+	certName := cert.ID()
+
+	metadata, err := ReadLiveMetadata(basePath, certName)
+	if err != nil || metadata.Issuer != issuer.Name {
+		return nil
+	}
+	liveCert, err := LoadLiveCertificate(NewLiveCertificatePaths(basePath, certName))
+	if err != nil || liveCert == nil {
+		return nil
+	}
+	certID, err := ARICertID(liveCert)
+	if err != nil {
+		return nil
+	}
+	directory, err := FetchACMEDirectory(ctx, client)
+	if err != nil || directory.RenewalInfo == "" || directory.NewOrder == "" || directory.NewNonce == "" {
+		return nil
+	}
+
+	return &OrderReplacement{Directory: directory, CertID: certID}
+}
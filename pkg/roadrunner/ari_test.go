@@ -0,0 +1,303 @@
+package roadrunner
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestARICertID(t *testing.T) {
+	// The example from RFC 9773 section 4.1
+	cert := &x509.Certificate{
+		AuthorityKeyId: []byte{0x69, 0x88, 0x5B, 0x6B, 0x87, 0x46, 0x40, 0x41, 0xE1, 0xB3, 0x7B, 0x84, 0x7B, 0xA0, 0xAE, 0x2C, 0xDE, 0x01, 0xC8, 0xD4},
+		SerialNumber:   big.NewInt(0x87654321),
+	}
+	certID, err := ARICertID(cert)
+	if err != nil {
+		t.Fatalf("ARICertID: %v", err)
+	}
+	if want := "aYhba4dGQEHhs3uEe6CuLN4ByNQ.AIdlQyE"; certID != want {
+		t.Errorf("ARICertID() = %v, want %v", certID, want)
+	}
+
+	// A serial without the high bit set has no leading zero
+	cert.SerialNumber = big.NewInt(0x01)
+	if certID, _ := ARICertID(cert); certID != "aYhba4dGQEHhs3uEe6CuLN4ByNQ.AQ" {
+		t.Errorf("ARICertID() with serial 1 = %v, want aYhba4dGQEHhs3uEe6CuLN4ByNQ.AQ", certID)
+	}
+
+	cert.AuthorityKeyId = nil
+	if _, err := ARICertID(cert); err == nil {
+		t.Error("ARICertID without an Authority Key Identifier succeeded")
+	}
+}
+
+func TestRenewalWindowShiftedEarlier(t *testing.T) {
+	day := 24 * time.Hour
+	now := time.Now()
+	previous := RenewalWindow{Start: now.Add(30 * day), End: now.Add(32 * day)}
+
+	tests := []struct {
+		name     string
+		window   RenewalWindow
+		previous RenewalWindow
+		want     bool
+	}{
+		{"no previous window", RenewalWindow{Start: now, End: now.Add(day)}, RenewalWindow{}, false},
+		{"unchanged", previous, previous, false},
+		{"later", RenewalWindow{Start: now.Add(40 * day), End: now.Add(42 * day)}, previous, false},
+		{"overlapping earlier", RenewalWindow{Start: now.Add(29 * day), End: now.Add(31 * day)}, previous, false},
+		{"ends before the previous start", RenewalWindow{Start: now.Add(-day), End: now.Add(day)}, previous, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.window.ShiftedEarlier(tt.previous); got != tt.want {
+				t.Errorf("ShiftedEarlier() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// issueTestCertificate creates a CA and a leaf certificate for the domain signed by it, returning the
+// parsed leaf, the PEM chain and the PEM leaf key
+func issueTestCertificate(t *testing.T, domain string, notBefore time.Time, notAfter time.Time) (*x509.Certificate, []byte, []byte) {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Roadrunner Test CA"},
+		SubjectKeyId:          []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20},
+		NotBefore:             notBefore.Add(-time.Hour),
+		NotAfter:              notAfter.Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(0x87654321),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, ca, leafKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(leafDER)
+
+	keyPEM, err := EncodePrivateKeyPEM(leafKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chainPEM := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER}), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})...)
+	return leaf, chainPEM, keyPEM
+}
+
+// ariTestSetup stores a live certificate issued by the fake ACME server's issuer and returns the config
+func ariTestSetup(t *testing.T, f *fakeACME, metadata LiveMetadata) (Config, Certificate, *x509.Certificate) {
+	t.Helper()
+	now := time.Now()
+	leaf, chainPEM, keyPEM := issueTestCertificate(t, "example.test", now.Add(-60*24*time.Hour), now.Add(30*24*time.Hour))
+
+	config := Config{}
+	config.Roadrunner.Config.WorkingDir = t.TempDir() + "/"
+	config.Roadrunner.Issuers = []Issuer{{Name: "fake", Endpoint: f.directoryURL()}}
+	cert := Certificate{Domains: []string{"example.test"}, Issuer: IssuerRefs{{Name: "fake"}}, RenewDays: 30}
+	config.Roadrunner.Certificates = []Certificate{cert}
+
+	if _, err := StoreLiveCertificate(config.Roadrunner.Config.WorkingDir, cert.ID(), chainPEM, keyPEM); err != nil {
+		t.Fatalf("StoreLiveCertificate: %v", err)
+	}
+	if err := WriteLiveMetadata(config.Roadrunner.Config.WorkingDir, cert.ID(), metadata); err != nil {
+		t.Fatalf("WriteLiveMetadata: %v", err)
+	}
+	return config, cert, leaf
+}
+
+func TestGetRenewalInfo(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	f := newFakeACME(t, key)
+	leaf, _, _ := issueTestCertificate(t, "example.test", time.Now(), time.Now().Add(90*24*time.Hour))
+	certID, _ := ARICertID(leaf)
+	window := RenewalWindow{Start: timeOf(time.Now().Add(60 * 24 * time.Hour)), End: timeOf(time.Now().Add(62 * 24 * time.Hour))}
+	f.renewalWindows[certID] = window
+
+	ctx := context.Background()
+	client := f.client()
+	directory, err := FetchACMEDirectory(ctx, client)
+	if err != nil {
+		t.Fatalf("FetchACMEDirectory: %v", err)
+	}
+
+	tests := []struct {
+		retryAfter string
+		want       time.Duration
+	}{
+		{"", DefaultARIPollInterval},
+		{"3600", time.Hour},
+		// Retry-After is kept within sane bounds
+		{"1", MinARIPollInterval},
+		{"604800", MaxARIPollInterval},
+	}
+	for _, tt := range tests {
+		f.renewalRetryAfter = tt.retryAfter
+		before := time.Now()
+		info, err := GetRenewalInfo(ctx, client, directory, leaf)
+		if err != nil {
+			t.Fatalf("GetRenewalInfo: %v", err)
+		}
+		if !info.SuggestedWindow.Start.Equal(window.Start) || !info.SuggestedWindow.End.Equal(window.End) {
+			t.Errorf("suggested window = %+v, want %+v", info.SuggestedWindow, window)
+		}
+		if info.RetryAfter.Before(before.Add(tt.want)) || info.RetryAfter.After(time.Now().Add(tt.want)) {
+			t.Errorf("with Retry-After %q RetryAfter = %v, want %v from now", tt.retryAfter, info.RetryAfter, tt.want)
+		}
+
+		renewAt := info.SelectRenewalTime()
+		if renewAt.Before(window.Start) || !renewAt.Before(window.End) {
+			t.Errorf("SelectRenewalTime() = %v, want within %+v", renewAt, window)
+		}
+	}
+
+	// The certID goes in the path as is
+	for _, requested := range f.renewalInfoRequests {
+		if requested != certID {
+			t.Errorf("renewal information requested for %v, want %v", requested, certID)
+		}
+	}
+
+	// A certificate the CA doesn't know about is an error
+	other, _, _ := issueTestCertificate(t, "other.test", time.Now(), time.Now().Add(time.Hour))
+	other.SerialNumber = big.NewInt(42)
+	if _, err := GetRenewalInfo(ctx, client, directory, other); err == nil {
+		t.Error("GetRenewalInfo for an unknown certificate succeeded")
+	}
+	if _, err := GetRenewalInfo(ctx, client, ACMEDirectory{}, leaf); err == nil {
+		t.Error("GetRenewalInfo without renewalInfo in the directory succeeded")
+	}
+}
+
+func TestARIRenewalTime(t *testing.T) {
+	day := 24 * time.Hour
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ctx := context.Background()
+
+	t.Run("window moved earlier renews now", func(t *testing.T) {
+		f := newFakeACME(t, key)
+		previous := RenewalWindow{Start: timeOf(time.Now().Add(20 * day)), End: timeOf(time.Now().Add(22 * day))}
+		previousRenewAt := previous.Start.Add(day)
+		config, cert, leaf := ariTestSetup(t, f, LiveMetadata{Issuer: "fake", RenewalWindow: &previous, RenewAt: &previousRenewAt})
+		certID, _ := ARICertID(leaf)
+
+		// The CA pulls the window in, as it would ahead of a revocation
+		f.renewalWindows[certID] = RenewalWindow{Start: timeOf(time.Now().Add(-day)), End: timeOf(time.Now().Add(day))}
+
+		renewAt := config.ARIRenewalTime(ctx, "[test]", cert, leaf, nil)
+		if time.Now().Before(renewAt) {
+			t.Fatalf("renewal due at %v after the window moved earlier, want now", renewAt)
+		}
+
+		metadata, err := ReadLiveMetadata(config.Roadrunner.Config.WorkingDir, cert.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if metadata.RenewalWindow == nil || !metadata.RenewalWindow.End.Equal(f.renewalWindows[certID].End) {
+			t.Errorf("stored window = %+v, want the new window", metadata.RenewalWindow)
+		}
+		if metadata.RenewalInfoRetryAfter == nil || !metadata.RenewalInfoRetryAfter.After(time.Now()) {
+			t.Errorf("stored retry after = %v, want a time in the future", metadata.RenewalInfoRetryAfter)
+		}
+	})
+
+	t.Run("selection inside the window is kept", func(t *testing.T) {
+		f := newFakeACME(t, key)
+		window := RenewalWindow{Start: timeOf(time.Now().Add(10 * day)), End: timeOf(time.Now().Add(12 * day))}
+		previousRenewAt := window.Start.Add(day)
+		config, cert, leaf := ariTestSetup(t, f, LiveMetadata{Issuer: "fake", RenewalWindow: &window, RenewAt: &previousRenewAt})
+		certID, _ := ARICertID(leaf)
+		f.renewalWindows[certID] = window
+
+		if renewAt := config.ARIRenewalTime(ctx, "[test]", cert, leaf, nil); !renewAt.Equal(previousRenewAt) {
+			t.Errorf("renewal due at %v, want the earlier selection %v", renewAt, previousRenewAt)
+		}
+
+		// The CA isn't asked again until its Retry-After has passed
+		config.ARIRenewalTime(ctx, "[test]", cert, leaf, nil)
+		if len(f.renewalInfoRequests) != 1 {
+			t.Errorf("renewal information was requested %d times, want 1", len(f.renewalInfoRequests))
+		}
+	})
+
+	t.Run("new window picks a time within it", func(t *testing.T) {
+		f := newFakeACME(t, key)
+		config, cert, leaf := ariTestSetup(t, f, LiveMetadata{Issuer: "fake"})
+		certID, _ := ARICertID(leaf)
+		window := RenewalWindow{Start: timeOf(time.Now().Add(5 * day)), End: timeOf(time.Now().Add(7 * day))}
+		f.renewalWindows[certID] = window
+
+		renewAt := config.ARIRenewalTime(ctx, "[test]", cert, leaf, nil)
+		if renewAt.Before(window.Start) || !renewAt.Before(window.End) {
+			t.Errorf("renewal due at %v, want within %+v", renewAt, window)
+		}
+	})
+
+	t.Run("issuer without renewal information uses renew_days", func(t *testing.T) {
+		f := newFakeACME(t, key)
+		f.noRenewalInfo = true
+		config, cert, leaf := ariTestSetup(t, f, LiveMetadata{Issuer: "fake"})
+
+		if renewAt, want := config.ARIRenewalTime(ctx, "[test]", cert, leaf, nil), RenewalTime(leaf, cert.RenewDays); !renewAt.Equal(want) {
+			t.Errorf("renewal due at %v, want %v from renew_days", renewAt, want)
+		}
+		if len(f.renewalInfoRequests) != 0 {
+			t.Errorf("renewal information was requested from an issuer that doesn't advertise it")
+		}
+	})
+}
+
+func TestOrderReplacement(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ctx := context.Background()
+	f := newFakeACME(t, key)
+	config, cert, leaf := ariTestSetup(t, f, LiveMetadata{Issuer: "fake"})
+	certID, _ := ARICertID(leaf)
+
+	replacement := config.orderReplacement(ctx, cert, config.Roadrunner.Issuers[0], f.client())
+	if replacement == nil || replacement.CertID != certID {
+		t.Fatalf("orderReplacement() = %+v, want certID %v", replacement, certID)
+	}
+	if replacement.Directory.NewOrder != f.server.URL+"/new-order" {
+		t.Errorf("replacement directory newOrder = %v", replacement.Directory.NewOrder)
+	}
+
+	// Only the issuer that produced the live certificate is told it is being replaced
+	if other := config.orderReplacement(ctx, cert, Issuer{Name: "other", Endpoint: f.directoryURL()}, f.client()); other != nil {
+		t.Errorf("orderReplacement() for another issuer = %+v, want nil", other)
+	}
+
+	f.noRenewalInfo = true
+	if replacement := config.orderReplacement(ctx, cert, config.Roadrunner.Issuers[0], f.client()); replacement != nil {
+		t.Errorf("orderReplacement() for an issuer without renewal information = %+v, want nil", replacement)
+	}
+}
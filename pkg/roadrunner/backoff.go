@@ -0,0 +1,222 @@
+package roadrunner
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kenmoini/roadrunner/internal/helpers"
+	"github.com/mholt/acmez"
+	"github.com/mholt/acmez/acme"
+	"gopkg.in/yaml.v2"
+)
+
+// RateLimitError is returned when an Issuer rate limits the client or asks it to retry later
+type RateLimitError struct {
+	// Issuer is the name of the Issuer that pushed back
+	Issuer string
+	// RetryAfter is when the Issuer asked to be retried, zero if it didn't say
+	RetryAfter time.Time
+	// Err is the underlying error from the Issuer
+	Err error
+}
+
+// Error describes the rate limit
+func (e *RateLimitError) Error() string {
+	if e.RetryAfter.IsZero() {
+		return fmt.Sprintf("rate limited by issuer [%v]: %v", e.Issuer, e.Err)
+	}
+	return fmt.Sprintf("rate limited by issuer [%v] until %v: %v", e.Issuer, e.RetryAfter.UTC().Format(time.RFC3339), e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *RateLimitError) Unwrap() error {
+	return e.Err
+}
+
+// asRateLimitError wraps the error in a RateLimitError when the CA returned a rateLimited
+// problem document or a Retry-After header
+func asRateLimitError(issuer string, client acmez.Client, err error) error {
+	var problem acme.Problem
+	rateLimited := errors.As(err, &problem) && problem.Type == acme.ProblemTypeRateLimited
+
+	var retryAfter time.Time
+	if transport, ok := client.Client.HTTPClient.Transport.(*instrumentedTransport); ok {
+		retryAfter = transport.RetryAfter()
+	}
+	if !rateLimited && retryAfter.IsZero() {
+		return err
+	}
+	return &RateLimitError{Issuer: issuer, RetryAfter: retryAfter, Err: err}
+}
+
+// BackoffState is the retry state of a certificate, persisted so restarts don't reset it
+type BackoffState struct {
+	// Failures is the number of consecutive failed attempts
+	Failures int `yaml:"failures"`
+	// LastFailure is when the last attempt failed
+	LastFailure time.Time `yaml:"last_failure"`
+	// NextAttempt is the earliest time the certificate is attempted again
+	NextAttempt time.Time `yaml:"next_attempt"`
+	// RateLimited is set when the last failure was a CA rate limit
+	RateLimited bool `yaml:"rate_limited,omitempty"`
+	// LastError is the error from the last failed attempt
+	LastError string `yaml:"last_error,omitempty"`
+}
+
+// backoffStatePath returns the path to the backoff state file for a named certificate
+func backoffStatePath(basePath string, name string) string {
+	return helpers.AppendSlash(basePath) + ".acme/backoff/" + name + ".yml"
+}
+
+// ReadBackoffState reads the backoff state for a certificate, returning an empty state if there is none
+func ReadBackoffState(basePath string, name string) (BackoffState, error) {
+	state := BackoffState{}
+	stateBytes, err := ReadFileToBytes(backoffStatePath(basePath, name))
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	err = yaml.Unmarshal(stateBytes, &state)
+	return state, err
+}
+
+// WriteBackoffState persists the backoff state for a certificate
+func WriteBackoffState(basePath string, name string, state BackoffState) error {
+	stateBytes, err := yaml.Marshal(state)
+	if err != nil {
+		return err
+	}
+	_, err = WriteByteFile(backoffStatePath(basePath, name), stateBytes, 0644, true)
+	return err
+}
+
+// ClearBackoffState removes the backoff state for a certificate after a successful attempt
+func ClearBackoffState(basePath string, name string) error {
+	err := os.Remove(backoffStatePath(basePath, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// RecordFailure counts a failed attempt and schedules the next one with exponential backoff,
+// waiting at least as long as the CA asked to when it rate limited us
+func (state BackoffState) RecordFailure(appConfig AppConfig, err error, now time.Time) BackoffState {
+	state.Failures++
+	state.LastFailure = now.UTC()
+	state.NextAttempt = now.Add(BackoffDelay(state.Failures, appConfig.RetryBackoffBase, appConfig.RetryBackoffMax)).UTC()
+	state.RateLimited = false
+	state.LastError = err.Error()
+
+	var rateLimitErr *RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		state.RateLimited = true
+		if rateLimitErr.RetryAfter.After(state.NextAttempt) {
+			state.NextAttempt = rateLimitErr.RetryAfter.UTC()
+		}
+	}
+	return state
+}
+
+// Waiting reports if the certificate is still backing off at the time
+func (state BackoffState) Waiting(now time.Time) bool {
+	return state.Failures > 0 && now.Before(state.NextAttempt)
+}
+
+// BackoffDelay returns the delay after a number of consecutive failures, doubling from base up to max
+func BackoffDelay(failures int, base time.Duration, max time.Duration) time.Duration {
+	if base <= 0 {
+		base = DefaultRetryBackoffBase
+	}
+	if max <= 0 {
+		max = DefaultRetryBackoffMax
+	}
+
+	delay := base
+	for i := 1; i < failures && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// IssuerBackoffState is the rate limit an Issuer put on us, which holds back every certificate using it
+type IssuerBackoffState struct {
+	// RateLimitedUntil is when the Issuer may be sent orders again
+	RateLimitedUntil time.Time `yaml:"rate_limited_until"`
+	// LastError is the rate limit error the Issuer returned
+	LastError string `yaml:"last_error,omitempty"`
+}
+
+// issuerBackoffMu stops parallel certificates rate limited by the same Issuer from losing each other's updates
+var issuerBackoffMu sync.Mutex
+
+// issuerBackoffStatePath returns the path to the backoff state file for a named Issuer
+func issuerBackoffStatePath(basePath string, name string) string {
+	return helpers.AppendSlash(basePath) + ".acme/backoff/issuers/" + name + ".yml"
+}
+
+// ReadIssuerBackoffState reads the backoff state for an Issuer, returning an empty state if there is none
+func ReadIssuerBackoffState(basePath string, name string) (IssuerBackoffState, error) {
+	state := IssuerBackoffState{}
+	stateBytes, err := ReadFileToBytes(issuerBackoffStatePath(basePath, name))
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	err = yaml.Unmarshal(stateBytes, &state)
+	return state, err
+}
+
+// RecordIssuerRateLimit holds the Issuer back until it asked to be retried, or for retry_backoff_base when
+// it didn't say, keeping any later deadline already recorded
+func RecordIssuerRateLimit(appConfig AppConfig, rateLimitErr *RateLimitError, now time.Time) (time.Time, error) {
+	issuerBackoffMu.Lock()
+	defer issuerBackoffMu.Unlock()
+
+	state, err := ReadIssuerBackoffState(appConfig.WorkingDir, rateLimitErr.Issuer)
+	if err != nil {
+		return time.Time{}, err
+	}
+	until := rateLimitErr.RetryAfter
+	if until.IsZero() {
+		until = now.Add(BackoffDelay(1, appConfig.RetryBackoffBase, appConfig.RetryBackoffMax))
+	}
+	if until.After(state.RateLimitedUntil) {
+		state.RateLimitedUntil = until.UTC()
+	}
+	state.LastError = rateLimitErr.Err.Error()
+
+	stateBytes, err := yaml.Marshal(state)
+	if err != nil {
+		return time.Time{}, err
+	}
+	_, err = WriteByteFile(issuerBackoffStatePath(appConfig.WorkingDir, rateLimitErr.Issuer), stateBytes, 0644, true)
+	return state.RateLimitedUntil, err
+}
+
+// EarliestRetry returns the earliest time a backing off certificate may be attempted again,
+// or the zero time if none are backing off
+func (config Config) EarliestRetry() time.Time {
+	now := time.Now()
+	earliest := time.Time{}
+	for _, cert := range config.Roadrunner.Certificates {
+		state, err := ReadBackoffState(config.Roadrunner.Config.WorkingDir, cert.ID())
+		if err != nil || !state.Waiting(now) {
+			continue
+		}
+		if earliest.IsZero() || state.NextAttempt.Before(earliest) {
+			earliest = state.NextAttempt
+		}
+	}
+	return earliest
+}
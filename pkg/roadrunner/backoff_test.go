@@ -0,0 +1,86 @@
+package roadrunner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestRecordIssuerRateLimit(t *testing.T) {
+	appConfig := AppConfig{WorkingDir: t.TempDir() + "/", RetryBackoffBase: 10 * time.Minute}
+	if err := os.MkdirAll(appConfig.WorkingDir+".acme/backoff/issuers", 0755); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		retryAfter time.Time
+		want       time.Time
+	}{
+		{"without Retry-After", time.Time{}, now.Add(10 * time.Minute)},
+		{"with Retry-After", now.Add(time.Hour), now.Add(time.Hour)},
+		{"earlier Retry-After keeps the later deadline", now.Add(30 * time.Minute), now.Add(time.Hour)},
+	}
+	for _, tt := range tests {
+		rateLimitErr := &RateLimitError{Issuer: "ca", RetryAfter: tt.retryAfter, Err: fmt.Errorf("too many orders")}
+		until, err := RecordIssuerRateLimit(appConfig, rateLimitErr, now)
+		if err != nil {
+			t.Fatalf("%v: RecordIssuerRateLimit: %v", tt.name, err)
+		}
+		state, err := ReadIssuerBackoffState(appConfig.WorkingDir, "ca")
+		if err != nil {
+			t.Fatalf("%v: ReadIssuerBackoffState: %v", tt.name, err)
+		}
+		if !until.Equal(tt.want) || !state.RateLimitedUntil.Equal(tt.want) || state.LastError != "too many orders" {
+			t.Errorf("%v: rate limited until %v, state %+v, want %v", tt.name, until, state, tt.want)
+		}
+	}
+}
+
+func TestIssueCertificateSkipsRateLimitedIssuer(t *testing.T) {
+	// The CA would only be reached if the rate limit were ignored
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.Error(w, "unexpected request", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	config := Config{}
+	config.Roadrunner.Config.WorkingDir = t.TempDir() + "/"
+	config.Roadrunner.Issuers = []Issuer{{Name: "ca", Endpoint: server.URL + "/directory"}}
+	if err := config.PrepareWorkingDirectory(); err != nil {
+		t.Fatalf("PrepareWorkingDirectory: %v", err)
+	}
+
+	// Another certificate was rate limited by the CA
+	until := time.Now().Add(time.Hour)
+	if _, err := RecordIssuerRateLimit(config.Roadrunner.Config, &RateLimitError{Issuer: "ca", RetryAfter: until, Err: fmt.Errorf("too many orders")}, time.Now()); err != nil {
+		t.Fatalf("RecordIssuerRateLimit: %v", err)
+	}
+
+	cert := Certificate{Domains: []string{"other.example.test"}, Issuer: IssuerRefs{{Name: "ca", Retries: 2}}}
+	_, err := config.IssueCertificate(context.Background(), "[test]", cert, nil, zap.NewNop())
+	var rateLimitErr *RateLimitError
+	if !errors.As(err, &rateLimitErr) || !rateLimitErr.RetryAfter.Equal(until.UTC()) {
+		t.Fatalf("IssueCertificate() = %v, want a rate limit until %v", err, until)
+	}
+	if n := atomic.LoadInt32(&requests); n != 0 {
+		t.Errorf("the rate limited CA got %d requests", n)
+	}
+
+	// The certificate waits for the issuer rather than retrying on its own schedule
+	backoff := BackoffState{}.RecordFailure(config.Roadrunner.Config, err, time.Now())
+	if !backoff.RateLimited || !backoff.NextAttempt.Equal(until.UTC()) {
+		t.Errorf("backoff = %+v, want rate limited until %v", backoff, until)
+	}
+}
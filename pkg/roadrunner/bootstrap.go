@@ -2,20 +2,17 @@ package roadrunner
 
 import (
 	"context"
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
 	"flag"
 	"fmt"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/kenmoini/roadrunner/internal/helpers"
 	"github.com/kenmoini/roadrunner/internal/logging"
-	"golang.org/x/exp/slices"
+	"github.com/kenmoini/roadrunner/internal/systemd"
 	"gopkg.in/yaml.v2"
 
-	"github.com/mholt/acmez"
-	"github.com/mholt/acmez/acme"
 	"go.uber.org/zap"
 )
 
@@ -33,281 +30,213 @@ func Entrypoint() {
 	// Setup engine config
 	cfg, err := NewConfig(cfgPath)
 	logging.CheckAndFail(err, "Failed to parse application configuration", true)
+	err = cfg.Validate()
+	logging.CheckAndFail(err, "Invalid application configuration", true)
 	RunningConfig = cfg
 
+	// Logging is important - replace with your own zap logger
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		logging.LogErrorToStdErr(err)
+	}
+
+	// A root context that is cancelled on SIGINT/SIGTERM, which cancels any in-flight orders
+	ctx, stop := NewShutdownContext(context.Background())
+	defer stop()
+
+	// Make sure no other roadrunner is working in the same directory
+	lock, err := LockWorkingDirectory(ctx, cfg.Roadrunner.Config.WorkingDirectory(), cfgPath.LockWait)
+	logging.CheckAndFail(err, "Failed to lock the working directory", true)
+	defer lock.Unlock()
+
+	// Create the Working Directory and the .acme tree if they don't exist
+	err = cfg.PrepareWorkingDirectory()
+	logging.CheckAndFail(err, "Failed to prepare the working directory", true)
+
+	// Pick up any listeners passed in by systemd socket activation
+	ActivatedListeners, err = systemd.Listeners()
+	logging.CheckAndFail(err, "Failed to use the socket activated listeners", false)
+
 	// Run the engine in the mode specified in the configuration
 	switch cfg.Roadrunner.Config.Mode {
 	case "daemon":
-		// Run the daemon preflight
+		// Run the daemon preflight and tell systemd we're ready
 		DaemonPreflightSetup()
+		sdNotify("READY=1")
 
-		// Start processing the certificates
-		cfg.ProcessConfiguration()
+		// Keep processing the certificates on the configured interval, reloading on SIGHUP
+		daemon := NewDaemon(cfgPath, cfg, logger)
+		go daemon.HandleReloadSignals(ctx)
+		go daemon.WatchFiles(ctx)
+		daemon.StartDaemonServers(ctx, cfg.Roadrunner.Config)
+		daemon.Run(ctx)
+		sdNotify("STOPPING=1")
 
 	case "cli":
 		// Run the CLI preflight
 		CLIPreflightSetup()
 
-		// Start processing the certificates
-		cfg.ProcessConfiguration()
+		// Process the certificates once and exit
+		summary := cfg.ProcessConfiguration(ctx, logger)
+		os.Exit(summary.ExitCode())
 
 	default:
 		logging.LogStdOutWarn("No/Invalid mode specified in configuration!  Proceeding as default CLI mode...")
 		// Run the CLI preflight
 		CLIPreflightSetup()
 
-		// Start processing the certificates
-		cfg.ProcessConfiguration()
+		// Process the certificates once and exit
+		summary := cfg.ProcessConfiguration(ctx, logger)
+		os.Exit(summary.ExitCode())
 	}
 }
 
-// ProcessConfiguration will process the Roadrunner configuration
-func (config Config) ProcessConfiguration() {
-
-	// Logging is important - replace with your own zap logger
-	logger, err := zap.NewDevelopment()
-	if err != nil {
-		logging.LogErrorToStdErr(err)
+// WorkingDirectory returns the configured working directory with a trailing slash, or the default one
+func (appConfig AppConfig) WorkingDirectory() string {
+	if appConfig.WorkingDir == "" {
+		return helpers.AppendSlash(DefaultWorkingDirectory)
 	}
+	return helpers.AppendSlash(appConfig.WorkingDir)
+}
 
-	// Set up a channel to listen to for interrupt signals
-	var runChan = make(chan os.Signal, 1)
-
-	// Create the Working Directory if it doesn't exist
+// PrepareWorkingDirectory creates the working directory and the .acme tree, using the default
+// working directory if one is not configured
+func (config *Config) PrepareWorkingDirectory() error {
 	// Check to see if the working directory configuration is set - if not use the default
 	if config.Roadrunner.Config.WorkingDir == "" {
 		logging.LogStdOutInfo("Working directory not specified in configuration, using default " + DefaultWorkingDirectory + "...")
-		config.Roadrunner.Config.WorkingDir = DefaultWorkingDirectory
-		RunningConfig.Roadrunner.Config.WorkingDir = helpers.AppendSlash(DefaultWorkingDirectory)
 	}
+	config.Roadrunner.Config.WorkingDir = config.Roadrunner.Config.WorkingDirectory()
 
 	// Check to see if the working directory exists - if not create it
 	if _, err := os.Stat(config.Roadrunner.Config.WorkingDir); os.IsNotExist(err) {
 		logging.LogStdOutInfo(fmt.Sprintf("Working directory [%v] does not exist, creating it now...", config.Roadrunner.Config.WorkingDir))
-		mkerr := os.MkdirAll(config.Roadrunner.Config.WorkingDir, 0755)
-		if mkerr != nil {
-			logging.CheckAndFail(mkerr, "Failed to create working directory", true)
+		if err := os.MkdirAll(config.Roadrunner.Config.WorkingDir, 0755); err != nil {
+			return fmt.Errorf("creating working directory: %v", err)
 		}
 	}
 
 	// Make a few extra directories
-	livemkerr := os.MkdirAll(helpers.AppendSlash(config.Roadrunner.Config.WorkingDir)+".acme/live", 0755)
-	if livemkerr != nil {
-		logging.CheckAndFail(livemkerr, "Failed to create live directory", true)
-	}
-	archmkerr := os.MkdirAll(helpers.AppendSlash(config.Roadrunner.Config.WorkingDir)+".acme/archive", 0755)
-	if archmkerr != nil {
-		logging.CheckAndFail(archmkerr, "Failed to create archive directory", true)
-	}
-	keysmkerr := os.MkdirAll(helpers.AppendSlash(config.Roadrunner.Config.WorkingDir)+".acme/keys", 0755)
-	if keysmkerr != nil {
-		logging.CheckAndFail(keysmkerr, "Failed to create keys directory", true)
-	}
-
-	// Set the base path for different directories
-	basePath := helpers.AppendSlash(config.Roadrunner.Config.WorkingDir)
-	var issuers = config.Roadrunner.Issuers
-
-	// Loop through the Certificates and process them
-	for i, cert := range config.Roadrunner.Certificates {
-		// Log out the start of the process
-		logging.LogStdOutInfo(fmt.Sprintf("[%d / %d - %v] Starting to process certificate...", i+1, len(config.Roadrunner.Certificates), cert.Domains[0]))
-
-		// Set some defaults and facts
-		spExists := false
-		localExists := false
-		localCertPath := basePath + ".acme/live/" + cert.Domains[0] + "/cert.pem"
-		account := acme.Account{}
-		client := acmez.Client{}
-
-		// A context allows us to cancel long-running ops
-		ctx := context.Background()
-
-		if cert.SaveType == "" {
-			cert.SaveType = "pem-pair"
+	for _, dir := range []string{"live", "archive", "keys", "backoff", "backoff/issuers", "notifications"} {
+		if err := os.MkdirAll(config.Roadrunner.Config.WorkingDir+".acme/"+dir, 0755); err != nil {
+			return fmt.Errorf("creating %v directory: %v", dir, err)
 		}
+	}
 
-		// Check to see if we can connect to the Issuer
-		// Get the matching named issuer
-		idx := slices.IndexFunc(issuers, func(i Issuer) bool { return i.Name == cert.Issuer })
-		if idx == -1 {
-			logging.CheckAndFail(fmt.Errorf("[%d / %d - %v] Failed to find matching issuer [%v] in the configuration", i+1, len(config.Roadrunner.Certificates), cert.Domains[0], cert.Issuer), "Failed to find the issuer in the configuration", false)
-		} else {
-			logging.LogStdOutInfo(fmt.Sprintf("[%d / %d - %v] Found matching issuer [%v] in the configuration...", i+1, len(config.Roadrunner.Certificates), cert.Domains[0], cert.Issuer))
-			matchingIssuer := issuers[idx]
-			fmt.Println(matchingIssuer)
-			// Try connecting to the Issuer
-
-			// Assemble the ConnectionInfo struct
-			cInfo := ConnectionInfo{
-				DirectoryURL:  matchingIssuer.Endpoint,
-				SkipTLSVerify: matchingIssuer.SkipTLSVerify,
-			}
-
-			// Create a new client
-			solvers := map[string]acmez.Solver{
-				acme.ChallengeTypeHTTP01:    mySolver{}, // provide these!
-				acme.ChallengeTypeDNS01:     mySolver{}, // provide these!
-				acme.ChallengeTypeTLSALPN01: mySolver{}, // provide these!
-			}
-
-			// Create an ACME client
-			client = CreateACMEClient(cInfo, solvers, logger)
-
-			// Create a new Account
-			account, err = CreateACMEClientAccount(cert.Email, client, logger)
-			if err != nil {
-				logging.CheckAndFail(err, "Failed to create the ACME client account", false)
-			}
-			//fmt.Println(account.Location)
-
-			if account.Status == "valid" {
-				logging.LogStdOutInfo(fmt.Sprintf("[%d / %d - %v] Successfully created an ACME client account...", i+1, len(config.Roadrunner.Certificates), cert.Domains[0]))
-			} else {
-				logging.CheckAndFail(fmt.Errorf("[%d / %d - %v] Failed to create an ACME client account", i+1, len(config.Roadrunner.Certificates), cert.Domains[0]), "Failed to create an ACME client account", false)
-			}
+	// Only roadrunner may reach the ACME account keys, not hooks running as other users
+	if err := os.Chmod(config.Roadrunner.Config.WorkingDir+".acme/keys", 0700); err != nil {
+		return fmt.Errorf("restricting the keys directory: %v", err)
+	}
 
-		}
+	// Move state kept under the first domain by older versions to the certificate name
+	return config.MigrateLegacyStore()
+}
 
-		// Check to see if the SavePath is specified, if so check it for a valid cert
-		if cert.SavePaths.Cert != "" {
-			// Check to see if the SavePath exists in the specified format
-			spCheck, err := FileExists(cert.SavePaths.Cert)
-			if err != nil {
-				logging.CheckAndFail(err, "Failed to check for the save path certificate file", true)
-			}
+// ProcessConfiguration will process every certificate in the Roadrunner configuration once
+func (config Config) ProcessConfiguration(ctx context.Context, logger *zap.Logger) RunSummary {
+	summary := RunSummary{}
 
-			// If the file exists, check to see if it's expired
-			if spCheck {
-				spExists = true
-				logging.LogStdOutInfo(fmt.Sprintf("[%d / %d - %v] Certificate file already exists in the specified SavePath, checking to see if it's expired...", i+1, len(config.Roadrunner.Certificates), cert.Domains[0]))
-			} else {
-				logging.LogStdOutInfo(fmt.Sprintf("[%d / %d - %v] Certificate file does not exist in the specified SavePath...", i+1, len(config.Roadrunner.Certificates), cert.Domains[0]))
+	// Drop metrics for certificates that are no longer configured
+	names := []string{}
+	for _, cert := range config.Roadrunner.Certificates {
+		names = append(names, cert.ID())
+	}
+	RoadrunnerMetrics.RetainCertificates(names)
+
+	// Accounts are checked with the CA once per run
+	ACMEAccounts.Reset()
+
+	// Batched deploy hooks are collected from every certificate and run once the workers are done
+	batch := NewDeployHookBatch()
+	workCtx := WithDeployHookBatch(ctx, batch)
+
+	// Hand the certificates out to the workers, certificates for the same domains stay on one worker
+	certs := config.Roadrunner.Certificates
+	groups := make(chan []int)
+	go func() {
+		defer close(groups)
+		for _, group := range certificateGroups(certs) {
+			select {
+			case groups <- group:
+			case <-ctx.Done():
+				return
 			}
 		}
-		// If this is a pem-pair check for the key too
-		if cert.SaveType == "pem-pair" {
-			if cert.SavePaths.Key != "" {
-				// Check to see if the SavePath exists in the specified format
-				spKeyCheck, err := FileExists(cert.SavePaths.Key)
-				if err != nil {
-					logging.CheckAndFail(err, "Failed to check for the save path certificate key file", true)
-				}
-
-				// If the file exists, check to see if it's expired
-				if spKeyCheck {
-					spExists = true
-					logging.LogStdOutInfo(fmt.Sprintf("[%d / %d - %v] Certificate key file already exists in the specified SavePath...", i+1, len(config.Roadrunner.Certificates), cert.Domains[0]))
-				} else {
-					logging.LogStdOutInfo(fmt.Sprintf("[%d / %d - %v] Certificate key file does not exist in the specified SavePath...", i+1, len(config.Roadrunner.Certificates), cert.Domains[0]))
+	}()
+
+	results := make([]*CertificateRunResult, len(certs))
+	workers := sync.WaitGroup{}
+	for w := 0; w < config.Roadrunner.Config.WorkerCount(); w++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for group := range groups {
+				for _, i := range group {
+					// Stop early if we're shutting down
+					if ctx.Err() != nil {
+						break
+					}
+
+					cert := certs[i]
+					logPrefix := fmt.Sprintf("[%d / %d - %v]", i+1, len(certs), cert.ID())
+
+					// Wait for any admin job working on the certificate
+					unlock, err := CertLocks.Lock(ctx, cert.ID())
+					if err != nil {
+						break
+					}
+
+					// Log out the start of the process
+					logging.LogStdOutInfo(logPrefix + " Starting to process certificate...")
+
+					result, err := config.ProcessCertificate(workCtx, logPrefix, cert, logger.With(zap.String("certificate", cert.ID())))
+					unlock()
+					if err != nil {
+						logging.Check(err, logPrefix+" Failed to process certificate")
+					}
+					results[i] = &CertificateRunResult{Name: cert.ID(), Result: result, Error: err}
+
+					// Log out the end of the process
+					logging.LogStdOutInfo(fmt.Sprintf("%v Finished processing certificate [%v]", logPrefix, result))
+					reportProgress(ctx)
 				}
 			}
+		}()
+	}
+	workers.Wait()
+
+	// Run each batched deploy hook once, failing the certificates that needed a hook that failed,
+	// then the post hooks that were waiting for them
+	batchFailures := batch.Run(ctx)
+	postFailures := batch.RunPostHooks(ctx, batchFailures)
+	reportProgress(ctx)
+	for i, result := range results {
+		if result == nil {
+			continue
 		}
-
-		// DEBUG: printout if spExists is true or false
-		logging.LogStdOutInfo(fmt.Sprintf("[%d / %d - %v] spExists: %v", i+1, len(config.Roadrunner.Certificates), cert.Domains[0], spExists))
-
-		// Check to see if the certificate already exists in the local location
-		localCheck, err := FileExists(localCertPath)
-		if err != nil {
-			logging.CheckAndFail(err, "Failed to check for the local certificate file", true)
+		logPrefix := fmt.Sprintf("[%d / %d - %v]", i+1, len(certs), result.Name)
+		if err, failed := batchFailures[certs[i].ID()]; failed {
+			logging.Check(err, logPrefix+" Failed to run the batched deploy hooks")
+			config.setPendingDeployHooks(logPrefix, result.Name, true)
+			results[i] = &CertificateRunResult{Name: result.Name, Result: ResultFailed, Error: err}
+		} else if err, failed := postFailures[certs[i].ID()]; failed && result.Result != ResultFailed {
+			logging.Check(err, logPrefix+" Failed to run the post hooks")
+			results[i] = &CertificateRunResult{Name: result.Name, Result: ResultFailed, Error: err}
 		}
+	}
 
-		// If the file exists, check to see if it's expired
-		if localCheck {
-			localExists = true
-			logging.LogStdOutInfo(fmt.Sprintf("[%d / %d - %v] Certificate file already exists in the local location, checking to see if it's expired...", i+1, len(config.Roadrunner.Certificates), cert.Domains[0]))
-			// Check for validity
-			// If Valid:
-			// - Check to see if SavePath was specified but not found - copy if so
-			// - Log out that it's valid and skip
-			// If Invalid:
-			// - Renew
-			// - Check to see if SavePath is specified - copy if so
-			// - log out that it's been renewed and copied
-		} else {
-			logging.LogStdOutInfo(fmt.Sprintf("[%d / %d - %v] Certificate file does not exist in the local location, creating it now...", i+1, len(config.Roadrunner.Certificates), cert.Domains[0]))
-			// Create the certificate
-
-			// Every certificate needs a key.
-			certPrivateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-			if err != nil {
-				fmt.Errorf("generating certificate key: %v", err)
-			}
-
-			// Once your client, account, and certificate key are all ready,
-			// it's time to request a certificate! The easiest way to do this
-			// is to use ObtainCertificate() and pass in your list of domains
-			// that you want on the cert. But if you need more flexibility, you
-			// should create a CSR yourself and use ObtainCertificateUsingCSR().
-			certs, err := client.ObtainCertificate(ctx, account, certPrivateKey, cert.Domains)
-			if err != nil {
-				fmt.Errorf("obtaining certificate: %v", err)
-			}
-
-			// ACME servers should usually give you the entire certificate chain
-			// in PEM format, and sometimes even alternate chains! It's up to you
-			// which one(s) to store and use, but whatever you do, be sure to
-			// store the certificate and key somewhere safe and secure, i.e. don't
-			// lose them!
-			for _, cert := range certs {
-				fmt.Printf("Certificate %q:\n%s\n\n", cert.URL, cert.ChainPEM)
-			}
-
-			// Check to see if SavePath is specified - copy if so
-			// Log out that it's been created and copied
+	// Report in the configured order, leaving out certificates skipped by a shutdown
+	events := []Event{}
+	for i, result := range results {
+		if result != nil {
+			summary.Add(certs[i], result.Result, result.Error)
+			events = append(events, config.CertificateEvents(certs[i], result.Result, result.Error)...)
 		}
-
-		// DEBUG: printout if localExists is true or false
-		logging.LogStdOutInfo(fmt.Sprintf("[%d / %d - %v] localExists: %v", i+1, len(config.Roadrunner.Certificates), cert.Domains[0], localExists))
-
-		// Log out the end of the process
-		logging.LogStdOutInfo(fmt.Sprintf("[%d / %d - %v] Finished processing certificate", i+1, len(config.Roadrunner.Certificates), cert.Domains[0]))
 	}
 
-	// // Loop through the Issuers and process them as Clients
-	// for i, issuer := range config.Roadrunner.Issuers {
-	// 	// Log out the start of the process
-	// 	logging.LogStdOutInfo(fmt.Sprintf("[%d / %d - %v] Starting to process issuer...", i+1, len(config.Roadrunner.Issuers), issuer.Name))
-
-	// 	// Assemble the ConnectionInfo struct
-	// 	cInfo := ConnectionInfo{
-	// 		DirectoryURL:  issuer.Endpoint,
-	// 		SkipTLSVerify: issuer.SkipTLSVerify,
-	// 	}
-
-	// 	// Assemble the OrderRequest struct
-	// 	oReq := Order{
-	// 		Email: issuer.Email,
-	// 		Domains: ,
-
-	// 	// Create a new client
-	// 	solvers := map[string]acmez.Solver{
-	// 		acme.ChallengeTypeHTTP01:    mySolver{}, // provide these!
-	// 		acme.ChallengeTypeDNS01:     mySolver{}, // provide these!
-	// 		acme.ChallengeTypeTLSALPN01: mySolver{}, // provide these!
-	// 	}
-
-	// 	client := CreateACMEClient(cInfo, solvers, logger)
-
-	// 	// DEBUG: Print out the client
-	// 	logging.LogStdOutInfo(fmt.Sprintf("Client: %+v", client))
-
-	// 	// Create the ACME account
-	// 	account := CreateACMEClientAccount(client, )
-
-	// 	// Log out the end of the process
-	// 	logging.LogStdOutInfo(fmt.Sprintf("[%d / %d - %v] Finished processing issuer", i+1, len(config.Roadrunner.Issuers), issuer.Name))
-	// }
-
-	// Block on this channel listeninf for those previously defined syscalls assign
-	// to variable so we can let the user know why the app is shutting down
-	interrupt := <-runChan
-
-	// If we get one of the pre-prescribed syscalls, gracefully terminate the app
-	// while alerting the user
-	logging.LogStdOutInfo(fmt.Sprintf("Roadrunner is shutting down due to %+v\n", interrupt))
-
+	logging.LogStdOutInfo(summary.String())
+	config.Notify(ctx, events)
+	return summary
 }
 
 //=================================================================================================
@@ -355,6 +284,10 @@ func ParseFlags() (CLIOpts, error) {
 	// to supply the configuration file
 	flag.StringVar(&configPath, "config", "", "path to config file, eg '-config=./config.yml'")
 
+	// Cron jobs can wait for a running daemon or another run to finish with the working directory
+	var lockWait time.Duration
+	flag.DurationVar(&lockWait, "lock-wait", 0, "how long to wait for another roadrunner using the working directory, eg '-lock-wait=5m'")
+
 	// Actually parse the flags
 	flag.Parse()
 
@@ -368,7 +301,9 @@ func ParseFlags() (CLIOpts, error) {
 	}
 
 	SetCLIOpts := CLIOpts{
-		Config: configPath}
+		Config:   configPath,
+		LockWait: lockWait,
+	}
 
 	// Return the configuration path
 	return SetCLIOpts, nil
@@ -398,7 +333,9 @@ func NewConfig(configPath CLIOpts) (*Config, error) {
 
 	// Open config file
 	file, err := os.Open(configPath.Config)
-	logging.CheckAndFail(err, "Failed to open config file", true)
+	if err != nil {
+		return nil, fmt.Errorf("opening config file: %v", err)
+	}
 	defer file.Close()
 
 	// Init new YAML decode
@@ -409,7 +346,126 @@ func NewConfig(configPath CLIOpts) (*Config, error) {
 		return nil, err
 	}
 
+	// Split certificates requesting several key types into one certificate per key type
+	certs, err := ExpandKeyTypes(config.Roadrunner.Certificates)
+	if err != nil {
+		return nil, err
+	}
+	config.Roadrunner.Certificates = certs
+
+	// restart_cmd was never run by older versions, it is now a deploy_hook
+	for i, cert := range config.Roadrunner.Certificates {
+		if cert.RestartCmd != "" {
+			logging.LogStdOutWarn(fmt.Sprintf("Certificate [%v] uses the deprecated restart_cmd, running it as a deploy_hook, move it to deploy_hook", cert.ID()))
+			config.Roadrunner.Certificates[i].DeployHook = append(append([]Hook{}, cert.DeployHook...), Hook{Shell: cert.RestartCmd})
+		}
+	}
+
 	//readConfig = config
 
 	return config, nil
 }
+
+// Validate checks the configuration for mistakes that would stop certificates from being processed
+func (config Config) Validate() error {
+	issuerNames := map[string]bool{}
+	for _, issuer := range config.Roadrunner.Issuers {
+		if issuer.Name == "" {
+			return fmt.Errorf("issuer with endpoint [%v] has no name", issuer.Endpoint)
+		}
+		if issuerNames[issuer.Name] {
+			return fmt.Errorf("issuer [%v] is defined more than once", issuer.Name)
+		}
+		if err := ValidateFileName(issuer.Name); err != nil {
+			return fmt.Errorf("issuer has an invalid name: %v", err)
+		}
+		if issuer.Endpoint == "" {
+			return fmt.Errorf("issuer [%v] has no endpoint", issuer.Name)
+		}
+		if issuer.MaxConcurrency < 0 {
+			return fmt.Errorf("issuer [%v] has a negative max_concurrency", issuer.Name)
+		}
+		issuerNames[issuer.Name] = true
+	}
+
+	notifierNames := map[string]bool{}
+	for i, notifier := range config.Roadrunner.Notifiers {
+		if notifier.Name == "" {
+			return fmt.Errorf("notifier %d has no name", i+1)
+		}
+		if notifierNames[notifier.Name] {
+			return fmt.Errorf("notifier [%v] is defined more than once", notifier.Name)
+		}
+		if err := ValidateFileName(notifier.Name); err != nil {
+			return fmt.Errorf("notifier %d has an invalid name: %v", i+1, err)
+		}
+		notifierNames[notifier.Name] = true
+		if err := notifier.Validate(); err != nil {
+			return fmt.Errorf("notifier [%v]: %v", notifier.Name, err)
+		}
+	}
+
+	appConfig := config.Roadrunner.Config
+	if appConfig.Workers < 0 {
+		return fmt.Errorf("workers can't be negative")
+	}
+	switch appConfig.DeployHookMode {
+	case "", DeployHookModeBatch, DeployHookModeImmediate:
+	default:
+		return fmt.Errorf("unknown deploy_hook_mode [%v], options are batch and immediate", appConfig.DeployHookMode)
+	}
+	if (appConfig.APITLSCertFile == "") != (appConfig.APITLSKeyFile == "") {
+		return fmt.Errorf("api_tls_cert_file and api_tls_key_file must be set together")
+	}
+	if appConfig.APIClientCAFile != "" && appConfig.APITLSCertFile == "" {
+		return fmt.Errorf("api_client_ca_file requires api_tls_cert_file and api_tls_key_file")
+	}
+
+	certIDs := map[string]bool{}
+	for i, cert := range config.Roadrunner.Certificates {
+		if len(cert.Domains) == 0 {
+			return fmt.Errorf("certificate %d has no domains", i+1)
+		}
+		if cert.Name != "" {
+			if err := ValidateCertificateName(cert.Name); err != nil {
+				return fmt.Errorf("certificate %d has an invalid name: %v", i+1, err)
+			}
+		}
+		if certIDs[cert.ID()] {
+			return fmt.Errorf("certificate name [%v] is used more than once, set a unique name on each certificate", cert.ID())
+		}
+		certIDs[cert.ID()] = true
+		if len(cert.Issuer) == 0 {
+			return fmt.Errorf("certificate [%v] has no issuer", cert.ID())
+		}
+		for _, ref := range cert.Issuer {
+			if !issuerNames[ref.Name] {
+				return fmt.Errorf("certificate [%v] references unknown issuer [%v]", cert.ID(), ref.Name)
+			}
+			if ref.Retries < 0 {
+				return fmt.Errorf("certificate [%v] has a negative retry budget for issuer [%v]", cert.ID(), ref.Name)
+			}
+		}
+		if err := ValidateKeySpec(cert.RequestOptions); err != nil {
+			return fmt.Errorf("certificate [%v] has an invalid key: %v", cert.ID(), err)
+		}
+		// A negative expiration would ask for a notAfter before the notBefore, which every CA rejects
+		if cert.RequestOptions.Expiration < 0 {
+			return fmt.Errorf("certificate [%v] has a negative expiration", cert.ID())
+		}
+		for stage, hooks := range map[string][]Hook{HookStagePre: cert.PreHook, HookStageDeploy: cert.DeployHook, HookStagePost: cert.PostHook} {
+			for j, hook := range hooks {
+				if err := hook.Validate(); err != nil {
+					return fmt.Errorf("certificate [%v] %v %d: %v", cert.ID(), stage, j+1, err)
+				}
+			}
+		}
+		switch cert.SaveType {
+		case "", "pem-pair", "haproxy":
+		default:
+			return fmt.Errorf("certificate [%v] has an unknown save_type [%v]", cert.ID(), cert.SaveType)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package roadrunner
+
+import (
+	"strings"
+	"testing"
+)
+
+// validTestConfig is the smallest configuration that passes Validate
+func validTestConfig() Config {
+	config := Config{}
+	config.Roadrunner.Issuers = []Issuer{{Name: "test", Endpoint: "https://ca.example.test/directory"}}
+	config.Roadrunner.Certificates = []Certificate{{Domains: []string{"example.test"}, Issuer: IssuerRefs{{Name: "test"}}}}
+	return config
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(config *Config)
+		wantErr string
+	}{
+		{"valid", func(config *Config) {}, ""},
+		{"expiration", func(config *Config) { config.Roadrunner.Certificates[0].RequestOptions.Expiration = 7 }, ""},
+		{"issuer name with a path", func(config *Config) {
+			config.Roadrunner.Issuers[0].Name = "../test"
+			config.Roadrunner.Certificates[0].Issuer[0].Name = "../test"
+		}, "can't contain path separators"},
+		{"notifier", func(config *Config) {
+			config.Roadrunner.Notifiers = []Notifier{{Name: "ops", URL: "https://hooks.example.test"}}
+		}, ""},
+		{"notifier without a name", func(config *Config) { config.Roadrunner.Notifiers = []Notifier{{URL: "https://hooks.example.test"}} }, "notifier 1 has no name"},
+		{"duplicate notifier", func(config *Config) {
+			config.Roadrunner.Notifiers = []Notifier{{Name: "ops", URL: "https://hooks.example.test"}, {Name: "ops", URL: "https://hooks.example.test"}}
+		}, "notifier [ops] is defined more than once"},
+		{"notifier name with a path", func(config *Config) {
+			config.Roadrunner.Notifiers = []Notifier{{Name: "../../etc/ops", URL: "https://hooks.example.test"}}
+		}, "notifier 1 has an invalid name"},
+		{"notifier name with dots", func(config *Config) {
+			config.Roadrunner.Notifiers = []Notifier{{Name: "..", URL: "https://hooks.example.test"}}
+		}, "notifier 1 has an invalid name"},
+		{"negative expiration", func(config *Config) { config.Roadrunner.Certificates[0].RequestOptions.Expiration = -1 }, "certificate [example.test] has a negative expiration"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := validTestConfig()
+			tt.change(&config)
+			err := config.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
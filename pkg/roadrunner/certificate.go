@@ -0,0 +1,418 @@
+package roadrunner
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kenmoini/roadrunner/internal/logging"
+	"github.com/mholt/acmez/acme"
+	"go.uber.org/zap"
+	"golang.org/x/exp/slices"
+)
+
+// CertificateResult is the outcome of processing a single certificate
+type CertificateResult string
+
+const (
+	// ResultUnchanged means the certificate was valid and already deployed
+	ResultUnchanged CertificateResult = "unchanged"
+	// ResultDeployed means the live certificate was valid and was re-deployed to the SavePaths
+	ResultDeployed CertificateResult = "deployed"
+	// ResultIssued means a new certificate was issued
+	ResultIssued CertificateResult = "issued"
+	// ResultRenewed means an existing certificate was renewed
+	ResultRenewed CertificateResult = "renewed"
+	// ResultFailed means the certificate could not be processed
+	ResultFailed CertificateResult = "failed"
+	// ResultDeferred means the certificate is due but is backing off after earlier failures
+	ResultDeferred CertificateResult = "deferred"
+)
+
+// CertificateRunResult is the result for a single certificate in a run
+type CertificateRunResult struct {
+	// Name is the name of the certificate
+	Name string
+	// Result is the outcome of processing the certificate
+	Result CertificateResult
+	// Error is the error encountered, if any
+	Error error
+}
+
+// RunSummary tallies the results of processing the configured certificates
+type RunSummary struct {
+	Results []CertificateRunResult
+}
+
+// Add records the result for a certificate
+func (summary *RunSummary) Add(cert Certificate, result CertificateResult, err error) {
+	summary.Results = append(summary.Results, CertificateRunResult{
+		Name:   cert.ID(),
+		Result: result,
+		Error:  err,
+	})
+}
+
+// Count returns the number of certificates with the given result
+func (summary RunSummary) Count(result CertificateResult) int {
+	count := 0
+	for _, r := range summary.Results {
+		if r.Result == result {
+			count++
+		}
+	}
+	return count
+}
+
+// ExitCode maps the run results to the CLI exit code
+// Failures take precedence over changes, so wrapper scripts always notice them
+// Certificates that are backing off still need work, so they count as failures
+func (summary RunSummary) ExitCode() int {
+	if summary.Count(ResultFailed)+summary.Count(ResultDeferred) > 0 {
+		return ExitCodeFailures
+	}
+	if summary.Count(ResultIssued)+summary.Count(ResultRenewed)+summary.Count(ResultDeployed) > 0 {
+		return ExitCodeChanged
+	}
+	return ExitCodeNothingToDo
+}
+
+// String returns a one line summary of the run
+func (summary RunSummary) String() string {
+	return fmt.Sprintf("Processed %d certificates: %d issued, %d renewed, %d deployed, %d unchanged, %d failed, %d deferred",
+		len(summary.Results), summary.Count(ResultIssued), summary.Count(ResultRenewed), summary.Count(ResultDeployed), summary.Count(ResultUnchanged), summary.Count(ResultFailed), summary.Count(ResultDeferred))
+}
+
+// ProcessCertificate makes sure a single certificate is issued, current and deployed
+func (config Config) ProcessCertificate(ctx context.Context, logPrefix string, cert Certificate, logger *zap.Logger) (CertificateResult, error) {
+	basePath := config.Roadrunner.Config.WorkingDir
+	certName := cert.ID()
+	livePaths := NewLiveCertificatePaths(basePath, certName)
+
+	if cert.SaveType == "" {
+		cert.SaveType = DefaultSaveType
+	}
+
+	// Check to see if the certificate already exists in the local location
+	liveCert, err := LoadLiveCertificate(livePaths)
+	if err != nil {
+		return ResultFailed, fmt.Errorf("checking for the local certificate file: %v", err)
+	}
+
+	if liveCert != nil {
+		RoadrunnerMetrics.RecordExpiry(certName, liveCert.NotAfter)
+		logging.LogStdOutInfo(logPrefix + " Certificate file already exists in the local location, checking to see if it's expired...")
+
+		// A revoked certificate, or one that no longer matches its configuration, is replaced straight away
+		metadata, _ := ReadLiveMetadata(basePath, certName)
+		revoked := metadata.RevokedAt != nil
+		drift := CertificateDrift(cert, liveCert, metadata)
+
+		renewAt := config.ARIRenewalTime(ctx, logPrefix, cert, liveCert, logger)
+		if !revoked && len(drift) == 0 && time.Now().Before(renewAt) {
+			logging.LogStdOutInfo(fmt.Sprintf("%v Certificate is valid until %v, renewal due at %v", logPrefix, liveCert.NotAfter.UTC().Format(time.RFC3339), renewAt.UTC().Format(time.RFC3339)))
+
+			// Check to see if SavePath was specified but is missing or modified - copy if so
+			drifted, err := DeploymentDrifted(cert, livePaths)
+			if err != nil {
+				return ResultFailed, err
+			}
+			if !drifted && !metadata.PendingDeployHooks {
+				return ResultUnchanged, nil
+			}
+
+			if drifted {
+				logging.LogStdOutInfo(logPrefix + " Certificate is missing or modified in the SavePaths, deploying it now...")
+				if err := DeployCertificate(cert, livePaths); err != nil {
+					return ResultFailed, fmt.Errorf("deploying the certificate to the SavePaths: %v", err)
+				}
+			} else {
+				logging.LogStdOutInfo(logPrefix + " Deploy hooks failed on the last check, running them again...")
+			}
+			if err := config.runDeployHooks(ctx, logPrefix, cert, livePaths, false); err != nil {
+				return ResultFailed, err
+			}
+			return ResultDeployed, nil
+		}
+
+		if revoked {
+			logging.LogStdOutInfo(logPrefix + " Certificate has been revoked, replacing it now...")
+		} else if len(drift) > 0 {
+			logging.LogStdOutInfo(fmt.Sprintf("%v Certificate no longer matches the configuration, %v, reissuing it now...", logPrefix, strings.Join(drift, ", ")))
+		} else {
+			logging.LogStdOutInfo(fmt.Sprintf("%v Certificate expires at %v and is due for renewal, renewing it now...", logPrefix, liveCert.NotAfter.UTC().Format(time.RFC3339)))
+		}
+	} else {
+		logging.LogStdOutInfo(logPrefix + " Certificate file does not exist in the local location, creating it now...")
+	}
+
+	// Don't hammer the CA while the certificate is backing off from earlier failures
+	backoff, err := ReadBackoffState(basePath, certName)
+	if err != nil {
+		logging.LogStdOutWarn(fmt.Sprintf("%v Failed to read the backoff state, attempting anyway: %v", logPrefix, err))
+	}
+	if backoff.Waiting(time.Now()) {
+		logging.LogStdOutInfo(fmt.Sprintf("%v Backing off after %d failed attempts, next attempt at %v", logPrefix, backoff.Failures, backoff.NextAttempt.Format(time.RFC3339)))
+		return ResultDeferred, fmt.Errorf("backing off until %v after %d failed attempts, last error: %v", backoff.NextAttempt.Format(time.RFC3339), backoff.Failures, backoff.LastError)
+	}
+
+	return config.RenewCertificate(ctx, logPrefix, cert, nil, logger)
+}
+
+// RenewCertificate obtains, stores and deploys a new certificate regardless of the live certificate expiry,
+// surrounded by the pre and post hooks
+// The live key is reused when certKey is passed in, otherwise a new key is generated
+func (config Config) RenewCertificate(ctx context.Context, logPrefix string, cert Certificate, certKey crypto.Signer, logger *zap.Logger) (CertificateResult, error) {
+	env := NewHookEnvironment(cert, NewLiveCertificatePaths(config.Roadrunner.Config.WorkingDir, cert.ID()))
+
+	// A failed pre hook means the challenges can't be solved, so the certificate isn't requested
+	result, err := ResultFailed, RunHooks(ctx, logPrefix, HookStagePre, cert.PreHook, env)
+	if err == nil {
+		result, err = config.obtainCertificate(ctx, logPrefix, cert, certKey, logger)
+	}
+
+	// The post hooks run whatever happened, eg to start a service the pre hooks stopped
+	env.Renewed = result == ResultIssued || result == ResultRenewed
+	env.Result = result
+	// They wait for batched deploy hooks so the pre, deploy, post order holds and RR_RESULT covers them
+	if batch := deployHookBatchFrom(ctx); batch != nil && len(cert.PostHook) > 0 && batch.Queued(cert.ID()) {
+		batch.DeferPostHooks(logPrefix, cert.PostHook, env)
+		return result, err
+	}
+	if postErr := RunHooks(ctx, logPrefix, HookStagePost, cert.PostHook, env); postErr != nil && err == nil {
+		return ResultFailed, postErr
+	}
+	return result, err
+}
+
+// obtainCertificate requests, stores and deploys a new certificate
+func (config Config) obtainCertificate(ctx context.Context, logPrefix string, cert Certificate, certKey crypto.Signer, logger *zap.Logger) (CertificateResult, error) {
+	basePath := config.Roadrunner.Config.WorkingDir
+	certName := cert.ID()
+
+	liveCert, err := LoadLiveCertificate(NewLiveCertificatePaths(basePath, certName))
+	if err != nil {
+		return ResultFailed, fmt.Errorf("checking for the local certificate file: %v", err)
+	}
+
+	// Request the certificate from the issuers in order of preference
+	RoadrunnerMetrics.RecordAttempt(certName)
+	issued, err := config.IssueCertificate(ctx, logPrefix, cert, certKey, logger)
+	if err != nil {
+		// Back off before the next attempt, unless we're just shutting down
+		if ctx.Err() == nil {
+			config.recordBackoff(logPrefix, certName, err)
+		}
+		return ResultFailed, fmt.Errorf("obtaining the certificate: %w", err)
+	}
+	if err := ClearBackoffState(basePath, certName); err != nil {
+		logging.Check(err, logPrefix+" Failed to clear the backoff state")
+	}
+	logging.LogStdOutInfo(fmt.Sprintf("%v Issuer [%v] produced the certificate, selected chain %v of %d offered...", logPrefix, issued.Issuer, issued.Chain.URL, issued.OfferedChains))
+
+	// Store the certificate and key in the live store
+	keyPEM, err := EncodePrivateKeyPEM(issued.PrivateKey)
+	if err != nil {
+		return ResultFailed, fmt.Errorf("encoding the certificate key: %v", err)
+	}
+	livePaths, err := StoreLiveCertificate(basePath, certName, issued.Chain.ChainPEM, keyPEM)
+	if err != nil {
+		return ResultFailed, fmt.Errorf("storing the certificate: %v", err)
+	}
+
+	// Record which issuer actually produced the live certificate
+	if err := WriteLiveMetadata(basePath, certName, NewLiveMetadata(issued)); err != nil {
+		logging.Check(err, logPrefix+" Failed to write the live certificate metadata")
+	}
+
+	// Check to see if SavePath is specified - copy if so
+	if err := DeployCertificate(cert, livePaths); err != nil {
+		return ResultFailed, fmt.Errorf("deploying the certificate to the SavePaths: %v", err)
+	}
+
+	// Keep the metrics up to date with the new certificate
+	if newCert, err := LoadLiveCertificate(livePaths); err == nil && newCert != nil {
+		RoadrunnerMetrics.RecordExpiry(certName, newCert.NotAfter)
+	}
+	RoadrunnerMetrics.RecordSuccess(certName, issued.Issuer, liveCert != nil)
+
+	// The certificate is stored either way, failed deploy hooks are run again on the next check
+	if err := config.runDeployHooks(ctx, logPrefix, cert, livePaths, true); err != nil {
+		return ResultFailed, err
+	}
+
+	if liveCert != nil {
+		logging.LogStdOutInfo(logPrefix + " Certificate renewed and stored...")
+		return ResultRenewed, nil
+	}
+	logging.LogStdOutInfo(logPrefix + " Certificate created and stored...")
+	return ResultIssued, nil
+}
+
+// runDeployHooks runs the deploy hooks for the deployed certificate, remembering if they failed
+// so they are run again on the next check
+// Batched hooks are queued for the end of the run when the context collects them, otherwise they run now
+func (config Config) runDeployHooks(ctx context.Context, logPrefix string, cert Certificate, livePaths LiveCertificatePaths, renewed bool) error {
+	env := NewHookEnvironment(cert, livePaths)
+	env.Renewed = renewed
+
+	hooks := cert.DeployHook
+	if batch := deployHookBatchFrom(ctx); batch != nil {
+		hooks = []Hook{}
+		for _, hook := range cert.DeployHook {
+			if hook.batched(config.Roadrunner.Config) {
+				batch.Add(hook, env)
+				continue
+			}
+			hooks = append(hooks, hook)
+		}
+	}
+
+	err := RunHooks(ctx, logPrefix, HookStageDeploy, hooks, env)
+	config.setPendingDeployHooks(logPrefix, cert.ID(), err != nil)
+	return err
+}
+
+// setPendingDeployHooks records if the deploy hooks of a certificate need to be run again
+func (config Config) setPendingDeployHooks(logPrefix string, certName string, pending bool) {
+	basePath := config.Roadrunner.Config.WorkingDir
+	metadata, err := ReadLiveMetadata(basePath, certName)
+	if err != nil {
+		logging.Check(err, logPrefix+" Failed to read the live certificate metadata")
+		return
+	}
+	if metadata.PendingDeployHooks == pending {
+		return
+	}
+	metadata.PendingDeployHooks = pending
+	if err := WriteLiveMetadata(basePath, certName, metadata); err != nil {
+		logging.Check(err, logPrefix+" Failed to write the live certificate metadata")
+	}
+}
+
+// recordBackoff counts a failed attempt at a certificate and persists when it may next be attempted
+func (config Config) recordBackoff(logPrefix string, certName string, err error) {
+	basePath := config.Roadrunner.Config.WorkingDir
+
+	backoff, readErr := ReadBackoffState(basePath, certName)
+	if readErr != nil {
+		logging.Check(readErr, logPrefix+" Failed to read the backoff state")
+	}
+	backoff = backoff.RecordFailure(config.Roadrunner.Config, err, time.Now())
+	if writeErr := WriteBackoffState(basePath, certName, backoff); writeErr != nil {
+		logging.Check(writeErr, logPrefix+" Failed to write the backoff state")
+	}
+
+	if backoff.RateLimited {
+		logging.LogStdOutWarn(fmt.Sprintf("%v Rate limited by the CA, next attempt at %v", logPrefix, backoff.NextAttempt.Format(time.RFC3339)))
+		return
+	}
+	logging.LogStdOutWarn(fmt.Sprintf("%v Attempt %d failed, next attempt at %v", logPrefix, backoff.Failures, backoff.NextAttempt.Format(time.RFC3339)))
+}
+
+// RevokeCertificate revokes the live certificate with the issuer that produced it
+// The request is signed with the certificate key and the revocation is recorded so the next check replaces it
+func (config Config) RevokeCertificate(ctx context.Context, logPrefix string, cert Certificate, reason int, logger *zap.Logger) error {
+	basePath := config.Roadrunner.Config.WorkingDir
+	certName := cert.ID()
+	livePaths := NewLiveCertificatePaths(basePath, certName)
+
+	liveCert, err := LoadLiveCertificate(livePaths)
+	if err != nil {
+		return fmt.Errorf("loading the live certificate: %v", err)
+	}
+	certKey, err := LoadLivePrivateKey(livePaths)
+	if err != nil {
+		return fmt.Errorf("loading the live certificate key: %v", err)
+	}
+	if liveCert == nil || certKey == nil {
+		return fmt.Errorf("there is no live certificate to revoke")
+	}
+
+	// Revoke with the issuer that produced the certificate, falling back to the preferred issuer
+	metadata, err := ReadLiveMetadata(basePath, certName)
+	if err != nil {
+		logging.LogStdOutWarn(fmt.Sprintf("%v Failed to read the live certificate metadata, using the preferred issuer: %v", logPrefix, err))
+	}
+	if metadata.RevokedAt != nil {
+		return fmt.Errorf("the live certificate was already revoked at %v", metadata.RevokedAt.Format(time.RFC3339))
+	}
+	issuerName := metadata.Issuer
+	if issuerName == "" {
+		issuerName = cert.Issuer[0].Name
+	}
+	idx := slices.IndexFunc(config.Roadrunner.Issuers, func(i Issuer) bool { return i.Name == issuerName })
+	if idx == -1 {
+		return fmt.Errorf("failed to find matching issuer [%v] in the configuration", issuerName)
+	}
+
+	client, err := CreateACMEClient(NewConnectionInfo(config.Roadrunner.Config, config.Roadrunner.Issuers[idx]), nil, logger)
+	if err != nil {
+		return fmt.Errorf("creating the ACME client: %v", err)
+	}
+
+	logging.LogStdOutInfo(fmt.Sprintf("%v Revoking certificate %v with issuer [%v]...", logPrefix, liveCert.SerialNumber.Text(16), issuerName))
+	if err := client.RevokeCertificate(ctx, acme.Account{}, liveCert, certKey, reason); err != nil {
+		return fmt.Errorf("revoking the certificate: %v", err)
+	}
+
+	revokedAt := time.Now().UTC()
+	metadata.RevokedAt = &revokedAt
+	if err := WriteLiveMetadata(basePath, certName, metadata); err != nil {
+		return fmt.Errorf("recording the revocation: %v", err)
+	}
+	logging.LogStdOutInfo(logPrefix + " Certificate revoked...")
+	return nil
+}
+
+// LoadLiveCertificate reads the leaf certificate from the live store, returning nil if there isn't one
+func LoadLiveCertificate(livePaths LiveCertificatePaths) (*x509.Certificate, error) {
+	exists, err := FileExists(livePaths.Cert)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	certBytes, err := ReadFileToBytes(livePaths.Cert)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := parseCertificateChain(certBytes)
+	if err != nil {
+		return nil, err
+	}
+	return certs[0], nil
+}
+
+// LoadLivePrivateKey reads the certificate key from the live store, returning nil if there isn't one
+func LoadLivePrivateKey(livePaths LiveCertificatePaths) (crypto.Signer, error) {
+	exists, err := FileExists(livePaths.PrivateKey)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	keyBytes, err := ReadFileToBytes(livePaths.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return DecodePrivateKeyPEM(keyBytes)
+}
+
+// RenewalTime returns when a certificate is due for renewal
+// Certificates are renewed renewDays before they expire, or once a third of their
+// lifetime remains when they are too short lived for the renewDays window
+func RenewalTime(cert *x509.Certificate, renewDays int) time.Time {
+	if renewDays <= 0 {
+		renewDays = DefaultRenewDays
+	}
+
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	renewBefore := time.Duration(renewDays) * 24 * time.Hour
+	if renewBefore >= lifetime {
+		renewBefore = lifetime / 3
+	}
+
+	return cert.NotAfter.Add(-renewBefore)
+}
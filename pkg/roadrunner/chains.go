@@ -0,0 +1,70 @@
+package roadrunner
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"github.com/mholt/acmez/acme"
+)
+
+// SelectPreferredChain picks the certificate chain whose topmost certificate was issued by the
+// preferred issuer Common Name, falling back to the default (first) chain offered by the server
+func SelectPreferredChain(chains []acme.Certificate, preferredChain string) (acme.Certificate, error) {
+	if len(chains) == 0 {
+		return acme.Certificate{}, fmt.Errorf("no certificate chains offered by the server")
+	}
+
+	// No preference means the default chain
+	if preferredChain == "" {
+		return chains[0], nil
+	}
+
+	for _, chain := range chains {
+		topmost, err := topmostCertificate(chain.ChainPEM)
+		if err != nil {
+			continue
+		}
+		if strings.EqualFold(topmost.Issuer.CommonName, preferredChain) {
+			return chain, nil
+		}
+	}
+
+	return chains[0], nil
+}
+
+// topmostCertificate returns the last certificate in a PEM encoded chain
+func topmostCertificate(chainPEM []byte) (*x509.Certificate, error) {
+	certs, err := parseCertificateChain(chainPEM)
+	if err != nil {
+		return nil, err
+	}
+	return certs[len(certs)-1], nil
+}
+
+// parseCertificateChain decodes all of the CERTIFICATE blocks from a PEM encoded chain
+func parseCertificateChain(chainPEM []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := chainPEM
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found in PEM chain")
+	}
+	return certs, nil
+}
@@ -0,0 +1,287 @@
+package roadrunner
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/kenmoini/roadrunner/internal/logging"
+	"github.com/kenmoini/roadrunner/internal/systemd"
+	"go.uber.org/zap"
+)
+
+// Daemon is the long-running renewal scheduler
+type Daemon struct {
+	// opts holds the CLI options used to re-read the configuration
+	opts   CLIOpts
+	logger *zap.Logger
+
+	mu     sync.RWMutex
+	config *Config
+
+	// ready is set once the first certificate check has completed
+	ready bool
+	// lastResults and lastChecked hold the outcome of the last check of each certificate
+	lastResults map[string]CertificateRunResult
+	lastChecked map[string]time.Time
+	// nextCheck is when the certificates are next scheduled to be checked
+	nextCheck time.Time
+	// progress is when the running check started or last finished a certificate, zero between checks
+	progress time.Time
+	// stallTimeout is how long a check may go without progress before the watchdog pings stop
+	stallTimeout time.Duration
+
+	// reload is signalled when the configuration should be re-read, with a channel for the outcome
+	// when the requester waits for it
+	reload chan chan error
+
+	// jobs holds the admin jobs submitted through the API
+	jobs *JobManager
+}
+
+// NewDaemon creates a Daemon for the loaded configuration
+func NewDaemon(opts CLIOpts, config *Config, logger *zap.Logger) *Daemon {
+	return &Daemon{
+		opts:         opts,
+		logger:       logger,
+		config:       config,
+		reload:       make(chan chan error, 1),
+		lastResults:  map[string]CertificateRunResult{},
+		lastChecked:  map[string]time.Time{},
+		stallTimeout: WatchdogStallTimeout,
+		jobs:         NewJobManager(),
+	}
+}
+
+// Config returns the configuration currently in use
+func (d *Daemon) Config() *Config {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.config
+}
+
+// Ready reports if the daemon has completed its first certificate check
+func (d *Daemon) Ready() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.ready
+}
+
+// recordRun keeps the results of a check cycle for the status API
+func (d *Daemon) recordRun(summary RunSummary, nextCheck time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	for _, result := range summary.Results {
+		d.lastResults[result.Name] = result
+		d.lastChecked[result.Name] = now
+	}
+	d.nextCheck = nextCheck
+	d.ready = true
+	d.progress = time.Time{}
+}
+
+// markProgress records that the running check has started or finished a certificate
+func (d *Daemon) markProgress() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.progress = time.Now()
+}
+
+// stalled reports if the running check has gone longer than the stall timeout without progress, and since when
+func (d *Daemon) stalled() (time.Time, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.progress, !d.progress.IsZero() && time.Since(d.progress) > d.stallTimeout
+}
+
+// recordResult keeps the result of a single certificate processed outside of a check cycle
+func (d *Daemon) recordResult(result CertificateRunResult) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.lastResults[result.Name] = result
+	d.lastChecked[result.Name] = time.Now()
+}
+
+// RequestReload asks the daemon to re-read the configuration, without blocking
+func (d *Daemon) RequestReload() {
+	select {
+	case d.reload <- nil:
+	default:
+	}
+}
+
+// ReloadAndWait asks the scheduler to re-read the configuration like RequestReload and waits until it has
+// The scheduler checks the certificates straight after a successful reload, once any running check finishes
+func (d *Daemon) ReloadAndWait(ctx context.Context) error {
+	reply := make(chan error, 1)
+	select {
+	case d.reload <- reply:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-reply:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Reload re-reads and validates the configuration file and swaps it in
+// The running configuration is kept if the new one fails to load or validate
+func (d *Daemon) Reload() error {
+	sdNotify("RELOADING=1")
+	defer sdNotify("READY=1")
+
+	newConfig, err := NewConfig(d.opts)
+	if err != nil {
+		return err
+	}
+	if err := newConfig.Validate(); err != nil {
+		return err
+	}
+
+	// The working directory is locked for the life of the process
+	if current := d.Config().Roadrunner.Config.WorkingDirectory(); newConfig.Roadrunner.Config.WorkingDirectory() != current {
+		return fmt.Errorf("working_dir can't be changed from [%v] by a reload, restart roadrunner instead", current)
+	}
+	if err := newConfig.PrepareWorkingDirectory(); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	d.config = newConfig
+	RunningConfig = newConfig
+	d.mu.Unlock()
+
+	logging.LogStdOutInfo(fmt.Sprintf("Configuration reloaded, %d certificates and %d issuers configured", len(newConfig.Roadrunner.Certificates), len(newConfig.Roadrunner.Issuers)))
+	return nil
+}
+
+// Run keeps processing the configuration on the configured interval until the context is cancelled
+// Errors on individual certificates are logged and retried on the next cycle
+func (d *Daemon) Run(ctx context.Context) {
+	logging.LogStdOutInfo("Daemon started")
+
+	// Keep the systemd watchdog fed while the scheduler is making progress
+	if interval := systemd.WatchdogInterval(); interval > 0 {
+		go d.runWatchdog(ctx, interval)
+	}
+
+	for {
+		config := d.Config()
+		sdNotify("STATUS=Checking certificates...")
+		d.markProgress()
+		summary := config.ProcessConfiguration(WithProgress(ctx, d.markProgress), d.logger)
+		if ctx.Err() != nil {
+			return
+		}
+
+		// Spread the next check out so a fleet of daemons doesn't hit the CA at the same time
+		wait := NextCheckDelay(config.Roadrunner.Config.CheckInterval, config.Roadrunner.Config.CheckJitter)
+		nextCheck := time.Now().Add(wait)
+
+		// Come back sooner for certificates that are backing off after a failure
+		if retryAt := config.EarliestRetry(); !retryAt.IsZero() && retryAt.Before(nextCheck) {
+			nextCheck = retryAt
+			wait = time.Until(retryAt)
+		}
+		d.recordRun(summary, nextCheck)
+		logging.LogStdOutInfo(fmt.Sprintf("Next certificate check at %v", nextCheck.Format(time.RFC3339)))
+		sdNotify(fmt.Sprintf("STATUS=%v, next check at %v", summary.String(), nextCheck.Format(time.RFC3339)))
+
+		if !d.waitForNextCycle(ctx, wait) {
+			return
+		}
+	}
+}
+
+// waitForNextCycle blocks until the next check is due or a reload succeeds, returning false on shutdown
+func (d *Daemon) waitForNextCycle(ctx context.Context, wait time.Duration) bool {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			return true
+		case reply := <-d.reload:
+			err := d.Reload()
+			if reply != nil {
+				reply <- err
+			}
+			if err != nil {
+				logging.LogStdOutWarn(fmt.Sprintf("Failed to reload configuration, keeping the running configuration: %v", err))
+				continue
+			}
+			// Process the new certificate set straight away
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// NextCheckDelay returns the check interval with a random amount of jitter added
+func NextCheckDelay(interval time.Duration, jitter time.Duration) time.Duration {
+	if interval <= 0 {
+		interval = DefaultCheckInterval
+	}
+	if jitter <= 0 {
+		return interval
+	}
+	return interval + time.Duration(rand.Int63n(int64(jitter)))
+}
+
+// runWatchdog pings the systemd watchdog on the interval until the context is cancelled
+// The pings stop while a check is stalled, so systemd restarts a scheduler that is stuck
+func (d *Daemon) runWatchdog(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	warned := false
+	for {
+		select {
+		case <-ticker.C:
+			if since, stalled := d.stalled(); stalled {
+				if !warned {
+					logging.LogStdOutWarn(fmt.Sprintf("No certificate has finished processing since %v, stopping the watchdog pings", since.Format(time.RFC3339)))
+					warned = true
+				}
+				continue
+			}
+			warned = false
+			sdNotify("WATCHDOG=1")
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// progressKey is the context key of the function a check reports its progress to
+type progressKey struct{}
+
+// WithProgress returns a context whose check calls report each time a certificate finishes processing
+func WithProgress(ctx context.Context, report func()) context.Context {
+	return context.WithValue(ctx, progressKey{}, report)
+}
+
+// reportProgress tells whoever is watching the check that it is still moving
+func reportProgress(ctx context.Context) {
+	if report, ok := ctx.Value(progressKey{}).(func()); ok {
+		report()
+	}
+}
+
+// sdNotify sends a state to systemd, logging rather than failing when it can't
+func sdNotify(state string) {
+	if _, err := systemd.Notify(state); err != nil {
+		logging.LogStdOutWarn(fmt.Sprintf("Failed to notify systemd of [%v]: %v", state, err))
+	}
+}
@@ -0,0 +1,179 @@
+package roadrunner
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// writeDaemonConfig writes a daemon configuration with a single certificate to a temporary file
+func writeDaemonConfig(t *testing.T, path string, workingDir string, domain string) {
+	t.Helper()
+	config := `roadrunner:
+  config:
+    mode: daemon
+    working_dir: ` + workingDir + `
+  issuers:
+  - name: test
+    endpoint: https://127.0.0.1:1/directory
+  certificates:
+  - domains: [` + domain + `]
+    issuer: test
+    email: admin@example.test
+`
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatalf("writing the config: %v", err)
+	}
+}
+
+func TestReloadAndWaitWakesScheduler(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yml")
+	writeDaemonConfig(t, configPath, dir+"/work", "one.example.test")
+
+	opts := CLIOpts{Config: configPath}
+	config, err := NewConfig(opts)
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	d := NewDaemon(opts, config, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The scheduler is waiting for a check that is an hour away
+	woken := make(chan bool, 1)
+	go func() { woken <- d.waitForNextCycle(ctx, time.Hour) }()
+
+	// A broken configuration is reported to the caller and the scheduler keeps waiting
+	if err := os.WriteFile(configPath, []byte("roadrunner: ["), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ReloadAndWait(ctx); err == nil {
+		t.Fatal("ReloadAndWait with a broken config succeeded")
+	}
+	select {
+	case <-woken:
+		t.Fatal("a failed reload woke the scheduler")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	writeDaemonConfig(t, configPath, dir+"/work", "two.example.test")
+	if err := d.ReloadAndWait(ctx); err != nil {
+		t.Fatalf("ReloadAndWait: %v", err)
+	}
+	select {
+	case again := <-woken:
+		if !again {
+			t.Fatal("the scheduler stopped instead of checking the certificates")
+		}
+	case <-ctx.Done():
+		t.Fatal("the reload didn't wake the scheduler")
+	}
+
+	if domains := d.Config().Roadrunner.Certificates[0].Domains; domains[0] != "two.example.test" {
+		t.Errorf("running certificates are %v after the reload, want two.example.test", domains)
+	}
+}
+
+func TestWatchdogStopsWhileSchedulerStalls(t *testing.T) {
+	dir := t.TempDir()
+	notifyPath := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: notifyPath, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("binding the notify socket: %v", err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", notifyPath)
+	t.Setenv("WATCHDOG_USEC", "100000")
+	t.Setenv("WATCHDOG_PID", "")
+
+	// The pre hook holds the only certificate of the check for a second
+	configPath := filepath.Join(dir, "config.yml")
+	config := `roadrunner:
+  config:
+    mode: daemon
+    working_dir: ` + dir + `/work
+  issuers:
+  - name: test
+    endpoint: https://127.0.0.1:1/directory
+  certificates:
+  - domains: [stalled.example.test]
+    issuer: test
+    email: admin@example.test
+    pre_hook:
+    - sleep 1
+`
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts := CLIOpts{Config: configPath}
+	loaded, err := NewConfig(opts)
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	if err := loaded.PrepareWorkingDirectory(); err != nil {
+		t.Fatalf("PrepareWorkingDirectory: %v", err)
+	}
+	previous := RunningConfig
+	RunningConfig = loaded
+	defer func() { RunningConfig = previous }()
+	d := NewDaemon(opts, loaded, zap.NewNop())
+	d.stallTimeout = 300 * time.Millisecond
+
+	// Record when each watchdog ping arrives
+	var mu sync.Mutex
+	pings := []time.Duration{}
+	start := time.Now()
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			if string(buf[:n]) == "WATCHDOG=1" {
+				mu.Lock()
+				pings = append(pings, time.Since(start))
+				mu.Unlock()
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		d.Run(ctx)
+	}()
+	time.Sleep(2 * time.Second)
+	cancel()
+	<-stopped
+
+	mu.Lock()
+	defer mu.Unlock()
+	count := func(from time.Duration, to time.Duration) int {
+		n := 0
+		for _, ping := range pings {
+			if ping >= from && ping < to {
+				n++
+			}
+		}
+		return n
+	}
+	if count(0, 300*time.Millisecond) == 0 {
+		t.Errorf("no pings while the check was making progress, got %v", pings)
+	}
+	if n := count(500*time.Millisecond, 900*time.Millisecond); n > 0 {
+		t.Errorf("%d pings while the check was stalled, got %v", n, pings)
+	}
+	if count(1500*time.Millisecond, 2*time.Second) == 0 {
+		t.Errorf("no pings once the check finished, got %v", pings)
+	}
+}
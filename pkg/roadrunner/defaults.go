@@ -1,9 +1,106 @@
 package roadrunner
 
+import "time"
+
 const (
 	// DefaultWorkingDirectory is the default working directory for the application
 	DefaultWorkingDirectory = "/etc/pki/roadrunner"
 
 	// DefaultSaveType is the default save type for the certificates when created
 	DefaultSaveType = "pem-pair"
+
+	// DefaultIssuerRetryDelay is the delay between retries against the same Issuer
+	DefaultIssuerRetryDelay = 10 * time.Second
+
+	// ValidityPeriodTolerance is how far the issued NotAfter may drift from the requested one
+	ValidityPeriodTolerance = 5 * time.Minute
+
+	// DefaultRetryBackoffBase is the delay after the first failed attempt at a certificate, doubling on each failure
+	DefaultRetryBackoffBase = 5 * time.Minute
+
+	// DefaultRetryBackoffMax caps the delay between failed attempts at a certificate
+	DefaultRetryBackoffMax = 24 * time.Hour
+
+	// DefaultARIPollInterval is how often renewal information is polled when the CA doesn't send a Retry-After
+	DefaultARIPollInterval = 6 * time.Hour
+
+	// MinARIPollInterval and MaxARIPollInterval bound the Retry-After of the renewal information
+	MinARIPollInterval = 1 * time.Minute
+	MaxARIPollInterval = 24 * time.Hour
+
+	// DefaultECDSAKeySize is the curve size of certificate keys when key_type is ecdsa or unset
+	DefaultECDSAKeySize = 256
+
+	// DefaultRSAKeySize is the size of certificate keys when key_type is rsa
+	DefaultRSAKeySize = 2048
+
+	// DefaultWorkers is how many certificates are processed at the same time
+	DefaultWorkers = 4
+
+	// DefaultRenewDays is the number of days before expiry that a certificate is renewed
+	DefaultRenewDays = 30
+
+	// DefaultCheckInterval is how often the daemon checks the certificates
+	DefaultCheckInterval = 12 * time.Hour
+
+	// DefaultHTTP01Listen is the address the http-01 challenge server binds when not socket activated
+	DefaultHTTP01Listen = ":80"
+
+	// HTTP01ListenerName is the FileDescriptorName of the socket activated http-01 listener
+	HTTP01ListenerName = "http-01"
+
+	// WorkingDirLockFile is the file in the working directory that is locked by the running process
+	WorkingDirLockFile = ".roadrunner.lock"
+
+	// WorkingDirLockPollInterval is how often a held working directory lock is retried while waiting for it
+	WorkingDirLockPollInterval = 1 * time.Second
+
+	// DefaultHookTimeout is how long a hook may run before it is killed
+	DefaultHookTimeout = 5 * time.Minute
+
+	// WatchdogStallTimeout is how long a check may go without finishing a certificate before the
+	// systemd watchdog pings stop and systemd restarts roadrunner, longer than the slowest healthy certificate
+	WatchdogStallTimeout = 1 * time.Hour
+
+	// MaxHookOutput is how much of the output of a hook is kept for the logs
+	MaxHookOutput = 64 * 1024
+
+	// DefaultExpiryWarningDays is how many days before expiry an overdue certificate is warned about
+	DefaultExpiryWarningDays = 14
+
+	// DefaultNotifierRetries is how many times a failed notification is retried
+	DefaultNotifierRetries = 3
+
+	// DefaultNotifierTimeout bounds each attempt to deliver a notification
+	DefaultNotifierTimeout = 10 * time.Second
+
+	// DefaultEmailRateLimit is the least time between two emails about the same certificate and event
+	DefaultEmailRateLimit = 24 * time.Hour
+
+	// DefaultWebhookRateLimit is the least time between two webhooks about the same certificate and event
+	DefaultWebhookRateLimit = 1 * time.Hour
+
+	// NotifierRetryDelay is the delay before the first notification retry, doubling on each retry
+	NotifierRetryDelay = 2 * time.Second
+
+	// MaxNotifierRetryDelay is the longest a notification waits to be retried, a receiver's Retry-After
+	// asking for longer gives up on the notification rather than holding up the check
+	MaxNotifierRetryDelay = 1 * time.Minute
+
+	// MaxRetainedJobs is how many finished admin jobs are kept for polling
+	MaxRetainedJobs = 100
 )
+
+const (
+	// ExitCodeNothingToDo is returned in CLI mode when every certificate was already current
+	ExitCodeNothingToDo = 0
+	// ExitCodeFailures is returned in CLI mode when any certificate failed to process
+	// It is kept apart from the 1 that invalid configuration, a held lock and other fatal errors exit with
+	ExitCodeFailures = 2
+	// ExitCodeChanged is returned in CLI mode when a certificate was issued, renewed or re-deployed
+	ExitCodeChanged = 3
+)
+
+// DefaultHookUserEnv is what a hook run as another user is passed on from roadrunner's environment
+// when it doesn't list its own env, so roadrunner's secrets don't leak to the less privileged user
+var DefaultHookUserEnv = []string{"PATH", "LANG", "TZ"}
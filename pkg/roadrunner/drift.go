@@ -0,0 +1,74 @@
+package roadrunner
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	"golang.org/x/net/idna"
+)
+
+// CertificateDrift compares the live certificate with its configuration and returns how they differ,
+// or nothing when the live certificate still matches
+// The issuer is only compared when the live metadata recorded one
+func CertificateDrift(cert Certificate, liveCert *x509.Certificate, metadata LiveMetadata) []string {
+	drift := []string{}
+
+	// The SANs must match the configured domains exactly
+	wanted := configuredIdentifiers(cert.Domains)
+	live := certificateIdentifiers(liveCert)
+	if !slices.Equal(wanted, live) {
+		drift = append(drift, fmt.Sprintf("domains changed from [%v] to [%v]", strings.Join(live, ", "), strings.Join(wanted, ", ")))
+	}
+
+	// The key must be the configured type and size
+	wantedType, wantedSize := KeySpec(cert.RequestOptions)
+	liveType, liveSize := PublicKeySpec(liveCert.PublicKey)
+	if wantedType != liveType || wantedSize != liveSize {
+		drift = append(drift, fmt.Sprintf("key changed from %v %d to %v %d", liveType, liveSize, wantedType, wantedSize))
+	}
+
+	// The certificate must come from one of the configured issuers
+	if metadata.Issuer != "" && !slices.Contains(cert.Issuer.Names(), metadata.Issuer) {
+		drift = append(drift, fmt.Sprintf("issuer [%v] is no longer configured", metadata.Issuer))
+	}
+
+	return drift
+}
+
+// configuredIdentifiers normalises the configured domains the same way they are put in the CSR
+func configuredIdentifiers(domains []string) []string {
+	identifiers := []string{}
+	for _, domain := range domains {
+		if ip := net.ParseIP(domain); ip != nil {
+			identifiers = append(identifiers, ip.String())
+			continue
+		}
+		if ascii, err := idna.ToASCII(domain); err == nil {
+			domain = ascii
+		}
+		identifiers = append(identifiers, strings.ToLower(domain))
+	}
+	return sortedUnique(identifiers)
+}
+
+// certificateIdentifiers returns the DNS and IP SANs of a certificate
+func certificateIdentifiers(cert *x509.Certificate) []string {
+	identifiers := []string{}
+	for _, name := range cert.DNSNames {
+		identifiers = append(identifiers, strings.ToLower(name))
+	}
+	for _, ip := range cert.IPAddresses {
+		identifiers = append(identifiers, ip.String())
+	}
+	return sortedUnique(identifiers)
+}
+
+// sortedUnique sorts the values and drops duplicates
+func sortedUnique(values []string) []string {
+	sort.Strings(values)
+	return slices.Compact(values)
+}
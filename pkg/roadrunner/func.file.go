@@ -2,6 +2,7 @@ package roadrunner
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/x509"
 	"encoding/pem"
@@ -119,24 +120,23 @@ func EncodeECDSAPrivateKeyPEM(privateKey *ecdsa.PrivateKey) (string, []byte) {
 	return string(pemEncoded), pemEncoded
 }
 
-// DecodeECDSAPrivateKeyPEM takes a ecdsa.PrivateKey directly and encodes it to a PEM block
-func DecodeECDSAPrivateKeyPEM(path string) *ecdsa.PrivateKey {
-	pemEncoded := LoadKeyFile(path)
-	block, _ := pem.Decode([]byte(pemEncoded))
-	x509Encoded := block.Bytes
-	privateKey, err := x509.ParseECPrivateKey(x509Encoded)
+// DecodeECDSAPrivateKeyPEM reads a PEM encoded ecdsa.PrivateKey from a file
+func DecodeECDSAPrivateKeyPEM(path string) (*ecdsa.PrivateKey, error) {
+	pemEncoded, err := LoadKeyFile(path)
 	if err != nil {
-		logging.Check(err, "Failed to parse ECDSA private key")
+		return nil, err
+	}
+	block, _ := pem.Decode(pemEncoded)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found in %v", path)
 	}
 
-	return privateKey
+	return x509.ParseECPrivateKey(block.Bytes)
 }
 
 // LoadKeyFile - loads a PEM key file
-func LoadKeyFile(fileName string) []byte {
-	inFile, err := ioutil.ReadFile(fileName)
-	logging.Check(err, "Failed to read key file: "+fileName)
-	return inFile
+func LoadKeyFile(fileName string) ([]byte, error) {
+	return ioutil.ReadFile(fileName)
 }
 
 // WriteByteFile creates a file from a byte slice with an optional filemode, only if it's new, and populates it - can force overwrite optionally
@@ -164,3 +164,31 @@ func WriteByteFile(path string, content []byte, mode int, overwrite bool) (bool,
 	}
 	return false, nil
 }
+
+// EncodePrivateKeyPEM encodes any supported private key as a PKCS #8 PEM block
+func EncodePrivateKeyPEM(privateKey crypto.Signer) ([]byte, error) {
+	pkcs8Encoded, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8Encoded}), nil
+}
+
+// DecodePrivateKeyPEM decodes a PKCS #8 PEM encoded private key
+func DecodePrivateKeyPEM(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found in the private key")
+	}
+
+	privateKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", privateKey)
+	}
+	return signer, nil
+}
@@ -1,6 +1,29 @@
 package roadrunner
 
+import "net"
+
 var (
 	// RunningConfig is the current configuration
 	RunningConfig *Config
+
+	// ActivatedListeners are the listeners passed in by systemd socket activation, keyed by name
+	ActivatedListeners = map[string]net.Listener{}
+
+	// HTTP01ChallengeSolver is the shared http-01 solver and challenge server
+	HTTP01ChallengeSolver = NewHTTP01Solver()
+
+	// ACMEAccounts shares the ACME accounts between the certificates processed in a run
+	ACMEAccounts = NewAccountRegistry()
+
+	// IssuerSlots bounds the orders running against each Issuer at the same time
+	IssuerSlots = NewIssuerLimiter()
+
+	// CertLocks stops the scheduler and admin jobs from working on the same certificate at once
+	CertLocks = NewCertificateLocks()
+
+	// NotifierLocks stops concurrent runs from overwriting each other's notifier rate limit state
+	NotifierLocks = NewCertificateLocks()
+
+	// RoadrunnerMetrics collects the values exported on the /metrics endpoint
+	RoadrunnerMetrics = NewMetrics()
 )
@@ -1,7 +1,7 @@
 package roadrunner
 
 import (
-	"log"
+	"fmt"
 	"path/filepath"
 	"strings"
 
@@ -82,7 +82,9 @@ func ReadCertFromFile(path string) (*x509.Certificate, error) {
 
 	// Read in PEM file
 	pem, err := readPEMFile(path, "CERTIFICATE")
-	logging.Check(err, "Failed to read the PEM file")
+	if err != nil {
+		return nil, err
+	}
 
 	// Decode to Certfificate object
 	return x509.ParseCertificate(pem.Bytes)
@@ -92,7 +94,9 @@ func ReadCertFromFile(path string) (*x509.Certificate, error) {
 // Types can include CERTIFICATE REQUEST, CERTIFICATE, PRIVATE KEY, PUBLIC KEY
 func readPEMFile(path string, matchType string) (*pem.Block, error) {
 	fileBytes, err := ReadFileToBytes(path)
-	logging.Check(err, "Failed to read the PEM file")
+	if err != nil {
+		return nil, err
+	}
 
 	return decodeByteSliceToPEM(fileBytes, matchType)
 }
@@ -101,7 +105,7 @@ func decodeByteSliceToPEM(pB []byte, matchType string) (*pem.Block, error) {
 	block, rest := pem.Decode(pB)
 
 	if block == nil || block.Type != matchType {
-		log.Fatal("failed to decode PEM block containing a " + matchType + ": " + string(rest))
+		return nil, fmt.Errorf("failed to decode PEM block containing a %v: %v", matchType, string(rest))
 	}
 
 	return block, nil
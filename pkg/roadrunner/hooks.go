@@ -0,0 +1,515 @@
+package roadrunner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/kenmoini/roadrunner/internal/logging"
+	"golang.org/x/sys/unix"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// HookStagePre runs before a certificate is requested, eg to stop a service holding port 80
+	HookStagePre = "pre_hook"
+	// HookStageDeploy runs after a certificate is deployed, eg to reload the services using it
+	HookStageDeploy = "deploy_hook"
+	// HookStagePost runs after every attempt to request a certificate, successful or not
+	HookStagePost = "post_hook"
+
+	// HookFailureFail fails the certificate when the hook fails
+	HookFailureFail = "fail"
+	// HookFailureWarn logs the failed hook and carries on
+	HookFailureWarn = "warn"
+
+	// DeployHookModeBatch runs each identical deploy hook once at the end of a run
+	DeployHookModeBatch = "batch"
+	// DeployHookModeImmediate runs the deploy hooks straight after each certificate is deployed
+	DeployHookModeImmediate = "immediate"
+)
+
+// Hook is a command run at a stage of processing a certificate
+type Hook struct {
+	// Command is the program and its arguments, run directly without a shell
+	Command []string `yaml:"command,omitempty"`
+	// Shell is a command line run with /bin/sh -c instead, for when pipes or expansions are needed
+	Shell string `yaml:"shell,omitempty"`
+	// Timeout is how long the hook may run before it is killed, defaults to 5m
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// OnFailure is "fail" to fail the certificate when the hook fails or "warn" to log it and carry on,
+	// defaults to "fail" for pre and deploy hooks and "warn" for post hooks
+	OnFailure string `yaml:"on_failure,omitempty"`
+	// Batch overrides deploy_hook_mode for a deploy hook, true runs it once at the end of the run
+	// and false runs it straight after the certificate is deployed
+	Batch *bool `yaml:"batch,omitempty"`
+	// User and Group run the hook with dropped privileges, by name or ID
+	// The group defaults to the primary group of the user and can't be set without a user
+	User  string `yaml:"user,omitempty"`
+	Group string `yaml:"group,omitempty"`
+	// WorkingDir is the directory the hook runs in, defaults to the directory roadrunner was started in
+	WorkingDir string `yaml:"working_dir,omitempty"`
+	// Env limits the environment passed on from roadrunner to these variable names, NAME=value entries set a value
+	// It defaults to everything, or DefaultHookUserEnv when a user is set
+	// The RR_ variables are always set, and HOME, USER and LOGNAME follow the user when one is set
+	Env []string `yaml:"env,omitempty"`
+}
+
+// UnmarshalYAML allows a hook to be a plain command line, split on whitespace and run without a shell,
+// so quotes are rejected rather than quietly passed on in the arguments
+func (hook *Hook) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var commandLine string
+	if err := unmarshal(&commandLine); err == nil {
+		// Without a shell quotes would end up in the arguments rather than grouping them
+		if strings.ContainsAny(commandLine, `'"`) {
+			return fmt.Errorf("hook [%v] has quotes, which a plain command line doesn't interpret, use a command list for arguments with spaces or shell to run it with /bin/sh -c", commandLine)
+		}
+		*hook = Hook{Command: strings.Fields(commandLine)}
+		return nil
+	}
+
+	type plain Hook
+	return unmarshal((*plain)(hook))
+}
+
+// Validate checks the hook can be run
+func (hook Hook) Validate() error {
+	if (len(hook.Command) == 0) == (hook.Shell == "") {
+		return fmt.Errorf("a hook needs either a command or a shell command line")
+	}
+	if hook.Timeout < 0 {
+		return fmt.Errorf("hook timeout can't be negative")
+	}
+	switch hook.OnFailure {
+	case "", HookFailureFail, HookFailureWarn:
+	default:
+		return fmt.Errorf("unknown on_failure [%v], options are fail and warn", hook.OnFailure)
+	}
+	// Setting only the group would keep roadrunner's uid, so root, and drop nothing
+	if hook.Group != "" && hook.User == "" {
+		return fmt.Errorf("hook group [%v] needs a user to run as", hook.Group)
+	}
+	if _, _, err := hook.credential(); err != nil {
+		return err
+	}
+	for _, entry := range hook.Env {
+		if name, _, _ := strings.Cut(entry, "="); name == "" {
+			return fmt.Errorf("invalid env entry [%v]", entry)
+		}
+	}
+	return nil
+}
+
+// credential returns who the hook runs as, or nil to run it as roadrunner
+func (hook Hook) credential() (*syscall.Credential, *user.User, error) {
+	if hook.User == "" && hook.Group == "" {
+		return nil, nil, nil
+	}
+	credential := &syscall.Credential{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid()), NoSetGroups: true}
+
+	var account *user.User
+	if hook.User != "" {
+		var err error
+		account, err = user.Lookup(hook.User)
+		if err != nil {
+			if account, err = user.LookupId(hook.User); err != nil {
+				return nil, nil, fmt.Errorf("unknown hook user [%v]", hook.User)
+			}
+		}
+		uid, err := strconv.ParseUint(account.Uid, 10, 32)
+		if err != nil {
+			return nil, nil, fmt.Errorf("hook user [%v] has a non-numeric uid", hook.User)
+		}
+		gid, err := strconv.ParseUint(account.Gid, 10, 32)
+		if err != nil {
+			return nil, nil, fmt.Errorf("hook user [%v] has a non-numeric gid", hook.User)
+		}
+		credential.Uid, credential.Gid = uint32(uid), uint32(gid)
+
+		// Take on the supplementary groups of the user rather than keeping roadrunner's
+		groupIDs, err := account.GroupIds()
+		if err != nil {
+			return nil, nil, fmt.Errorf("looking up the groups of hook user [%v]: %v", hook.User, err)
+		}
+		credential.NoSetGroups = false
+		credential.Groups = []uint32{}
+		for _, groupID := range groupIDs {
+			if id, err := strconv.ParseUint(groupID, 10, 32); err == nil {
+				credential.Groups = append(credential.Groups, uint32(id))
+			}
+		}
+	}
+
+	if hook.Group != "" {
+		group, err := user.LookupGroup(hook.Group)
+		if err != nil {
+			if group, err = user.LookupGroupId(hook.Group); err != nil {
+				return nil, nil, fmt.Errorf("unknown hook group [%v]", hook.Group)
+			}
+		}
+		gid, err := strconv.ParseUint(group.Gid, 10, 32)
+		if err != nil {
+			return nil, nil, fmt.Errorf("hook group [%v] has a non-numeric gid", hook.Group)
+		}
+		credential.Gid = uint32(gid)
+	}
+
+	return credential, account, nil
+}
+
+// environment returns the environment of the hook, the RR_ variables along with what roadrunner
+// was started with, limited to the allowed variables when there is an allowlist
+func (hook Hook) environment(env HookEnvironment, account *user.User) []string {
+	allowed := hook.Env
+	if allowed == nil && account != nil {
+		allowed = DefaultHookUserEnv
+	}
+
+	variables := os.Environ()
+	if allowed != nil {
+		variables = []string{}
+		for _, entry := range allowed {
+			if strings.Contains(entry, "=") {
+				variables = append(variables, entry)
+			} else if value, ok := os.LookupEnv(entry); ok {
+				variables = append(variables, entry+"="+value)
+			}
+		}
+	}
+	if account != nil {
+		variables = append(variables, "HOME="+account.HomeDir, "USER="+account.Username, "LOGNAME="+account.Username)
+	}
+	return append(variables, env.Variables()...)
+}
+
+// String returns the command line of the hook for the logs
+func (hook Hook) String() string {
+	if hook.Shell != "" {
+		return hook.Shell
+	}
+	return strings.Join(hook.Command, " ")
+}
+
+// failurePolicy returns what a failure of the hook means at the stage
+func (hook Hook) failurePolicy(stage string) string {
+	if hook.OnFailure != "" {
+		return hook.OnFailure
+	}
+	if stage == HookStagePost {
+		return HookFailureWarn
+	}
+	return HookFailureFail
+}
+
+// batched reports if the deploy hook is run once at the end of the run rather than for each certificate
+func (hook Hook) batched(appConfig AppConfig) bool {
+	if hook.Batch != nil {
+		return *hook.Batch
+	}
+	return appConfig.DeployHookMode != DeployHookModeImmediate
+}
+
+// HookEnvironment is what the hooks are told about the certificate through RR_ environment variables
+type HookEnvironment struct {
+	CertName string
+	Domains  []string
+	// CertPath and KeyPath are the deployed files, or the live store when there are no save_paths
+	CertPath string
+	KeyPath  string
+	// Renewed is set when a new certificate was obtained, rather than an existing one re-deployed
+	Renewed bool
+	// Result is the outcome of the attempt, for post hooks
+	Result CertificateResult
+}
+
+// NewHookEnvironment describes the certificate for its hooks
+func NewHookEnvironment(cert Certificate, livePaths LiveCertificatePaths) HookEnvironment {
+	env := HookEnvironment{
+		CertName: cert.ID(),
+		Domains:  cert.Domains,
+		CertPath: livePaths.FullChain,
+		KeyPath:  livePaths.PrivateKey,
+	}
+	if cert.SavePaths.Cert != "" {
+		env.CertPath = cert.SavePaths.Cert
+		switch {
+		case cert.SaveType == "haproxy":
+			env.KeyPath = cert.SavePaths.Cert
+		case cert.SavePaths.Key != "":
+			env.KeyPath = cert.SavePaths.Key
+		}
+	}
+	return env
+}
+
+// Variables returns the RR_ environment variables
+func (env HookEnvironment) Variables() []string {
+	variables := []string{
+		"RR_CERT_NAME=" + env.CertName,
+		"RR_DOMAINS=" + strings.Join(env.Domains, " "),
+		"RR_CERT_PATH=" + env.CertPath,
+		"RR_KEY_PATH=" + env.KeyPath,
+		fmt.Sprintf("RR_RENEWED=%t", env.Renewed),
+	}
+	if env.Result != "" {
+		variables = append(variables, "RR_RESULT="+string(env.Result))
+	}
+	return variables
+}
+
+// RunHooks runs the hooks of a stage in order, logging their output
+// A failing hook that fails the certificate stops the remaining hooks and is returned
+func RunHooks(ctx context.Context, logPrefix string, stage string, hooks []Hook, env HookEnvironment) error {
+	for i, hook := range hooks {
+		hookPrefix := fmt.Sprintf("%v [%v %d]", logPrefix, stage, i+1)
+		logging.LogStdOutInfo(fmt.Sprintf("%v Running %v", hookPrefix, hook))
+
+		output, err := runHook(ctx, hook, env)
+		logHookOutput(hookPrefix, output)
+		if err == nil {
+			continue
+		}
+
+		if hook.failurePolicy(stage) == HookFailureWarn {
+			logging.LogStdOutWarn(fmt.Sprintf("%v Failed, carrying on: %v", hookPrefix, err))
+			continue
+		}
+		return fmt.Errorf("%v %d [%v] failed: %v", stage, i+1, hook, err)
+	}
+	return nil
+}
+
+// runHook runs a single hook with its timeout, returning its combined output
+func runHook(ctx context.Context, hook Hook, env HookEnvironment) ([]byte, error) {
+	timeout := hook.Timeout
+	if timeout == 0 {
+		timeout = DefaultHookTimeout
+	}
+
+	var cmd *exec.Cmd
+	if hook.Shell != "" {
+		cmd = exec.Command("/bin/sh", "-c", hook.Shell)
+	} else {
+		cmd = exec.Command(hook.Command[0], hook.Command[1:]...)
+	}
+	credential, account, err := hook.credential()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Env = hook.environment(env, account)
+	cmd.Dir = hook.WorkingDir
+	output := &hookOutput{limit: MaxHookOutput}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	// Run the hook in its own process group so a timeout kills anything it started too
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Credential: credential}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return output.Bytes(), err
+	case <-timer.C:
+		unix.Kill(-cmd.Process.Pid, unix.SIGKILL)
+		<-done
+		return output.Bytes(), fmt.Errorf("timed out after %v", timeout)
+	case <-ctx.Done():
+		unix.Kill(-cmd.Process.Pid, unix.SIGKILL)
+		<-done
+		return output.Bytes(), ctx.Err()
+	}
+}
+
+// logHookOutput logs each line the hook printed
+func logHookOutput(hookPrefix string, output []byte) {
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		if line := strings.TrimRight(scanner.Text(), " \t\r"); line != "" {
+			logging.LogStdOutInfo(hookPrefix + " " + line)
+		}
+	}
+}
+
+// hookOutput keeps the output of a hook up to a limit so a chatty hook can't exhaust memory
+type hookOutput struct {
+	bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+// Write keeps what fits within the limit and discards the rest
+func (o *hookOutput) Write(p []byte) (int, error) {
+	if room := o.limit - o.Buffer.Len(); room < len(p) {
+		if !o.truncated {
+			o.truncated = true
+			if room > 0 {
+				o.Buffer.Write(p[:room])
+			}
+			o.Buffer.WriteString("\n[output truncated]\n")
+		}
+		return len(p), nil
+	}
+	return o.Buffer.Write(p)
+}
+
+// deployHookBatchKey is the context key of the DeployHookBatch collecting the deploy hooks of a run
+type deployHookBatchKey struct{}
+
+// DeployHookBatch collects the batched deploy hooks of a run so each identical hook runs once,
+// after every certificate that needs it has been deployed
+type DeployHookBatch struct {
+	mu    sync.Mutex
+	hooks []*batchedHook
+	index map[string]*batchedHook
+	// queued are the certificates with a hook in the batch, and posts their post hooks waiting for it
+	queued map[string]bool
+	posts  []deferredPostHooks
+}
+
+// deferredPostHooks are the post hooks of a certificate waiting for its batched deploy hooks
+type deferredPostHooks struct {
+	logPrefix string
+	hooks     []Hook
+	env       HookEnvironment
+}
+
+// batchedHook is a deploy hook and the certificates that asked for it
+type batchedHook struct {
+	hook  Hook
+	certs []HookEnvironment
+}
+
+// NewDeployHookBatch creates an empty DeployHookBatch
+func NewDeployHookBatch() *DeployHookBatch {
+	return &DeployHookBatch{index: map[string]*batchedHook{}, queued: map[string]bool{}}
+}
+
+// WithDeployHookBatch returns a context that collects batched deploy hooks in the batch
+func WithDeployHookBatch(ctx context.Context, batch *DeployHookBatch) context.Context {
+	return context.WithValue(ctx, deployHookBatchKey{}, batch)
+}
+
+// deployHookBatchFrom returns the batch collecting deploy hooks, or nil when they run straight away
+func deployHookBatchFrom(ctx context.Context) *DeployHookBatch {
+	batch, _ := ctx.Value(deployHookBatchKey{}).(*DeployHookBatch)
+	return batch
+}
+
+// Add queues the hook for the certificate, merging it with an identical hook queued by another certificate
+func (b *DeployHookBatch) Add(hook Hook, env HookEnvironment) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Hooks are identical when everything about them is, down to who they run as
+	identity, _ := yaml.Marshal(hook)
+	key := string(identity)
+	batched, ok := b.index[key]
+	if !ok {
+		batched = &batchedHook{hook: hook}
+		b.index[key] = batched
+		b.hooks = append(b.hooks, batched)
+	}
+	batched.certs = append(batched.certs, env)
+	b.queued[env.CertName] = true
+}
+
+// Queued reports whether the certificate has a hook waiting in the batch
+func (b *DeployHookBatch) Queued(certName string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.queued[certName]
+}
+
+// DeferPostHooks holds back the post hooks of a certificate until its batched deploy hooks have run
+func (b *DeployHookBatch) DeferPostHooks(logPrefix string, hooks []Hook, env HookEnvironment) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.posts = append(b.posts, deferredPostHooks{logPrefix: logPrefix, hooks: hooks, env: env})
+}
+
+// Run runs each queued hook once, in the order they were first queued
+// It returns the error for each certificate whose batched hook failed the certificate
+func (b *DeployHookBatch) Run(ctx context.Context) map[string]error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	failed := map[string]error{}
+	for _, batched := range b.hooks {
+		env := mergeHookEnvironments(batched.certs)
+		logPrefix := fmt.Sprintf("[batch - %v]", strings.Join(certNames(batched.certs), ", "))
+
+		if err := RunHooks(ctx, logPrefix, HookStageDeploy, []Hook{batched.hook}, env); err != nil {
+			for _, cert := range batched.certs {
+				if failed[cert.CertName] == nil {
+					failed[cert.CertName] = err
+				}
+			}
+		}
+	}
+	b.hooks = nil
+	b.index = map[string]*batchedHook{}
+	b.queued = map[string]bool{}
+	return failed
+}
+
+// RunPostHooks runs the deferred post hooks in the order they were deferred, with RR_RESULT set to
+// failed for the certificates in batchFailures
+// It returns the error for each certificate whose post hooks failed the certificate
+func (b *DeployHookBatch) RunPostHooks(ctx context.Context, batchFailures map[string]error) map[string]error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	failed := map[string]error{}
+	for _, post := range b.posts {
+		env := post.env
+		if batchFailures[env.CertName] != nil {
+			env.Result = ResultFailed
+		}
+		if err := RunHooks(ctx, post.logPrefix, HookStagePost, post.hooks, env); err != nil {
+			failed[env.CertName] = err
+		}
+	}
+	b.posts = nil
+	return failed
+}
+
+// mergeHookEnvironments describes every certificate of a batched hook, with the values space separated
+func mergeHookEnvironments(certs []HookEnvironment) HookEnvironment {
+	merged := HookEnvironment{}
+	names, certPaths, keyPaths := []string{}, []string{}, []string{}
+	for _, cert := range certs {
+		names = append(names, cert.CertName)
+		merged.Domains = append(merged.Domains, cert.Domains...)
+		certPaths = append(certPaths, cert.CertPath)
+		keyPaths = append(keyPaths, cert.KeyPath)
+		merged.Renewed = merged.Renewed || cert.Renewed
+	}
+	merged.CertName = strings.Join(names, " ")
+	merged.CertPath = strings.Join(certPaths, " ")
+	merged.KeyPath = strings.Join(keyPaths, " ")
+	return merged
+}
+
+// certNames returns the names of the certificates
+func certNames(certs []HookEnvironment) []string {
+	names := []string{}
+	for _, cert := range certs {
+		names = append(names, cert.CertName)
+	}
+	return names
+}
@@ -0,0 +1,165 @@
+package roadrunner
+
+import (
+	"context"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestHookUnmarshalYAML(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		want    Hook
+		wantErr string
+	}{
+		{"command line", `systemctl reload nginx`, Hook{Command: []string{"systemctl", "reload", "nginx"}}, ""},
+		{"command list", `command: [logger, -t, roadrunner, "restarting roadrunner"]`, Hook{Command: []string{"logger", "-t", "roadrunner", "restarting roadrunner"}}, ""},
+		{"shell", `shell: "logger -t roadrunner 'restarting roadrunner'"`, Hook{Shell: "logger -t roadrunner 'restarting roadrunner'"}, ""},
+		{"single quotes", `logger -t roadrunner 'restarting roadrunner'`, Hook{}, "use a command list"},
+		{"double quotes", `'logger -t roadrunner "restarting roadrunner"'`, Hook{}, "use a command list"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook := Hook{}
+			err := yaml.Unmarshal([]byte(tt.yaml), &hook)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Unmarshal(%q) error = %v, want one containing %q", tt.yaml, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unmarshal(%q): %v", tt.yaml, err)
+			}
+			if !reflect.DeepEqual(hook, tt.want) {
+				t.Errorf("Unmarshal(%q) = %+v, want %+v", tt.yaml, hook, tt.want)
+			}
+		})
+	}
+}
+
+func TestHookValidate(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skipf("looking up the current user: %v", err)
+	}
+	tests := []struct {
+		name    string
+		hook    Hook
+		wantErr string
+	}{
+		{"command", Hook{Command: []string{"true"}}, ""},
+		{"user", Hook{Command: []string{"true"}, User: current.Uid}, ""},
+		{"user and group", Hook{Command: []string{"true"}, User: current.Uid, Group: current.Gid}, ""},
+		{"group without user", Hook{Command: []string{"true"}, Group: current.Gid}, "needs a user"},
+		{"command and shell", Hook{Command: []string{"true"}, Shell: "true"}, "either a command or a shell"},
+		{"bad env", Hook{Command: []string{"true"}, Env: []string{"=value"}}, "invalid env entry"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.hook.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHookEnvironment(t *testing.T) {
+	t.Setenv("PATH", "/usr/bin:/bin")
+	t.Setenv("ROADRUNNER_TEST_SECRET", "hunter2")
+	account := &user.User{Username: "hookuser", HomeDir: "/home/hookuser"}
+	env := HookEnvironment{CertName: "example"}
+
+	tests := []struct {
+		name    string
+		hook    Hook
+		account *user.User
+		want    []string
+		notWant []string
+	}{
+		{"everything as roadrunner", Hook{}, nil, []string{"PATH=/usr/bin:/bin", "ROADRUNNER_TEST_SECRET=hunter2", "RR_CERT_NAME=example"}, nil},
+		{"allowlist", Hook{Env: []string{"PATH", "SERVICE=nginx"}}, nil, []string{"PATH=/usr/bin:/bin", "SERVICE=nginx", "RR_CERT_NAME=example"}, []string{"ROADRUNNER_TEST_SECRET=hunter2"}},
+		{"minimal as another user", Hook{User: "hookuser"}, account, []string{"PATH=/usr/bin:/bin", "HOME=/home/hookuser", "USER=hookuser", "RR_CERT_NAME=example"}, []string{"ROADRUNNER_TEST_SECRET=hunter2"}},
+		{"allowlist as another user", Hook{User: "hookuser", Env: []string{"ROADRUNNER_TEST_SECRET"}}, account, []string{"ROADRUNNER_TEST_SECRET=hunter2", "LOGNAME=hookuser"}, []string{"PATH=/usr/bin:/bin"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			variables := map[string]bool{}
+			for _, variable := range tt.hook.environment(env, tt.account) {
+				variables[variable] = true
+			}
+			for _, want := range tt.want {
+				if !variables[want] {
+					t.Errorf("environment is missing %v", want)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if variables[notWant] {
+					t.Errorf("environment has %v", notWant)
+				}
+			}
+		})
+	}
+}
+
+func TestDeployHookBatchDefersPostHooks(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "hooks.log")
+	logHook := func(stage string) Hook {
+		return Hook{Shell: `echo "` + stage + ` $RR_CERT_NAME $RR_RESULT" >> ` + logPath}
+	}
+
+	batch := NewDeployHookBatch()
+	batch.Add(logHook("deploy"), HookEnvironment{CertName: "good"})
+	batch.Add(Hook{Shell: "exit 1"}, HookEnvironment{CertName: "bad"})
+	for _, name := range []string{"good", "bad"} {
+		if !batch.Queued(name) {
+			t.Fatalf("Queued(%q) = false after Add", name)
+		}
+		post := []Hook{logHook("post")}
+		if name == "good" {
+			post = append(post, Hook{Shell: "exit 1", OnFailure: HookFailureFail})
+		}
+		batch.DeferPostHooks("["+name+"]", post, HookEnvironment{CertName: name, Result: ResultRenewed})
+	}
+
+	ctx := context.Background()
+	batchFailures := batch.Run(ctx)
+	if batchFailures["bad"] == nil || batchFailures["good"] != nil {
+		t.Fatalf("Run() failures = %v, want only bad", batchFailures)
+	}
+	if batch.Queued("good") {
+		t.Error("Queued(good) = true after Run")
+	}
+	postFailures := batch.RunPostHooks(ctx, batchFailures)
+	if postFailures["good"] == nil || postFailures["bad"] != nil {
+		t.Errorf("RunPostHooks() failures = %v, want only good", postFailures)
+	}
+
+	// The post hooks ran after the batch, and see the certificate failed when its batched hook did
+	log, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "deploy good \npost good renewed\npost bad failed\n"
+	if string(log) != want {
+		t.Errorf("hooks ran as\n%swant\n%s", log, want)
+	}
+
+	if failures := batch.RunPostHooks(ctx, nil); len(failures) != 0 {
+		t.Errorf("post hooks ran twice: %v", failures)
+	}
+}
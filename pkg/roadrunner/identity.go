@@ -0,0 +1,110 @@
+package roadrunner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/kenmoini/roadrunner/internal/logging"
+	"golang.org/x/net/idna"
+)
+
+// certificateNamePattern is what a certificate name must look like to be used as a directory name
+var certificateNamePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]*$`)
+
+// ID returns the stable identity of the certificate that keys its store paths, metrics and logs
+// It is the configured name, or one derived from the first domain when no name is set
+func (cert Certificate) ID() string {
+	if cert.Name != "" {
+		return cert.Name
+	}
+	if len(cert.Domains) == 0 {
+		return ""
+	}
+	return DefaultCertificateName(cert.Domains[0])
+}
+
+// DefaultCertificateName derives a name that is safe to use as a directory from a domain,
+// eg *.example.com becomes wildcard.example.com
+func DefaultCertificateName(domain string) string {
+	name := strings.ToLower(strings.TrimSpace(domain))
+	if ascii, err := idna.ToASCII(name); err == nil {
+		name = ascii
+	}
+	if strings.HasPrefix(name, "*.") {
+		name = "wildcard." + strings.TrimPrefix(name, "*.")
+	}
+
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+	if name == "" || name[0] == '.' || name[0] == '-' {
+		name = "_" + name
+	}
+	return name
+}
+
+// ValidateCertificateName checks a configured certificate name is safe to use as a directory name
+func ValidateCertificateName(name string) error {
+	if !certificateNamePattern.MatchString(name) {
+		return fmt.Errorf("name [%v] may only contain letters, digits, '.', '-' and '_', and can't start with '.' or '-'", name)
+	}
+	return nil
+}
+
+// ValidateFileName checks a configured name, eg of an issuer or notifier, is safe to use as a file name
+// in the working directory
+func ValidateFileName(name string) error {
+	if strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+		return fmt.Errorf("name [%v] can't contain path separators or '..'", name)
+	}
+	return nil
+}
+
+// MigrateLegacyStore moves live, archive and backoff state kept under the first domain of a certificate,
+// as older versions did, to the certificate ID
+// Nothing is moved when the first domain is ambiguous or the ID already has state of its own
+func (config Config) MigrateLegacyStore() error {
+	acmeDir := config.Roadrunner.Config.WorkingDir + ".acme/"
+
+	ids := map[string]bool{}
+	firstDomains := map[string]int{}
+	for _, cert := range config.Roadrunner.Certificates {
+		ids[cert.ID()] = true
+		firstDomains[cert.Domains[0]]++
+	}
+
+	for _, cert := range config.Roadrunner.Certificates {
+		legacy, id := cert.Domains[0], cert.ID()
+		if legacy == id || ids[legacy] || firstDomains[legacy] > 1 || filepath.Base(legacy) != legacy {
+			continue
+		}
+
+		moves := map[string]string{
+			acmeDir + "live/" + legacy:             acmeDir + "live/" + id,
+			acmeDir + "archive/" + legacy:          acmeDir + "archive/" + id,
+			acmeDir + "backoff/" + legacy + ".yml": acmeDir + "backoff/" + id + ".yml",
+		}
+		for from, to := range moves {
+			if _, err := os.Stat(from); err != nil {
+				continue
+			}
+			if _, err := os.Stat(to); err == nil {
+				logging.LogStdOutWarn(fmt.Sprintf("[%v] Not migrating %v, %v already exists", id, from, to))
+				continue
+			}
+			if err := os.Rename(from, to); err != nil {
+				return fmt.Errorf("migrating %v to %v: %v", from, to, err)
+			}
+			logging.LogStdOutInfo(fmt.Sprintf("[%v] Migrated %v to %v", id, from, to))
+		}
+	}
+
+	return nil
+}
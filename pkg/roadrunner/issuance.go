@@ -0,0 +1,223 @@
+package roadrunner
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kenmoini/roadrunner/internal/logging"
+	"github.com/mholt/acmez"
+	"github.com/mholt/acmez/acme"
+	"go.uber.org/zap"
+	"golang.org/x/exp/slices"
+)
+
+// IssuedCertificate is the result of a successful order against an Issuer
+type IssuedCertificate struct {
+	// Issuer is the name of the Issuer that produced the certificate
+	Issuer string
+	// Endpoint is the ACME directory of the Issuer that produced the certificate
+	Endpoint string
+	// Chain is the selected certificate chain
+	Chain acme.Certificate
+	// PrivateKey is the certificate private key
+	PrivateKey crypto.Signer
+	// OfferedChains is the number of chains the server offered
+	OfferedChains int
+}
+
+// IssueCertificate requests the certificate from each of the referenced issuers in order,
+// retrying each one up to its retry budget before falling back to the next issuer
+// A new key is generated unless an existing certKey is passed in
+func (config Config) IssueCertificate(ctx context.Context, logPrefix string, cert Certificate, certKey crypto.Signer, logger *zap.Logger) (IssuedCertificate, error) {
+	if len(cert.Issuer) == 0 {
+		return IssuedCertificate{}, fmt.Errorf("no issuer configured for the certificate")
+	}
+
+	var lastErr error
+	for _, ref := range cert.Issuer {
+		// Get the matching named issuer
+		idx := slices.IndexFunc(config.Roadrunner.Issuers, func(i Issuer) bool { return i.Name == ref.Name })
+		if idx == -1 {
+			lastErr = fmt.Errorf("failed to find matching issuer [%v] in the configuration", ref.Name)
+			logging.LogStdOutWarn(fmt.Sprintf("%v %v", logPrefix, lastErr))
+			continue
+		}
+		matchingIssuer := config.Roadrunner.Issuers[idx]
+		logging.LogStdOutInfo(fmt.Sprintf("%v Found matching issuer [%v] in the configuration...", logPrefix, ref.Name))
+
+		// An issuer that rate limited any certificate is left alone until it said to come back
+		issuerBackoff, err := ReadIssuerBackoffState(config.Roadrunner.Config.WorkingDir, ref.Name)
+		if err != nil {
+			logging.LogStdOutWarn(fmt.Sprintf("%v Failed to read the backoff state of issuer [%v], trying it anyway: %v", logPrefix, ref.Name, err))
+		}
+		if time.Now().Before(issuerBackoff.RateLimitedUntil) {
+			lastErr = fmt.Errorf("issuer [%v]: %w", ref.Name, &RateLimitError{Issuer: ref.Name, RetryAfter: issuerBackoff.RateLimitedUntil, Err: fmt.Errorf("still rate limited: %v", issuerBackoff.LastError)})
+			logging.LogStdOutWarn(fmt.Sprintf("%v Issuer [%v] is rate limiting until %v, skipping it", logPrefix, ref.Name, issuerBackoff.RateLimitedUntil.Format(time.RFC3339)))
+			continue
+		}
+
+		for attempt := 0; attempt <= ref.Retries; attempt++ {
+			if attempt > 0 {
+				logging.LogStdOutInfo(fmt.Sprintf("%v Retrying issuer [%v], attempt %d of %d...", logPrefix, ref.Name, attempt+1, ref.Retries+1))
+				select {
+				case <-time.After(DefaultIssuerRetryDelay):
+				case <-ctx.Done():
+					return IssuedCertificate{}, ctx.Err()
+				}
+			}
+
+			release, err := IssuerSlots.Acquire(ctx, matchingIssuer)
+			if err != nil {
+				return IssuedCertificate{}, err
+			}
+			issued, err := config.issueFromIssuer(ctx, logPrefix, cert, matchingIssuer, certKey, logger)
+			release()
+			if err == nil {
+				return issued, nil
+			}
+			lastErr = fmt.Errorf("issuer [%v]: %w", ref.Name, err)
+			RoadrunnerMetrics.RecordFailure(ref.Name)
+			logging.LogStdOutWarn(fmt.Sprintf("%v Failed to obtain the certificate from issuer [%v]: %v", logPrefix, ref.Name, err))
+
+			// Retrying a CA that is rate limiting us only makes it worse, move on to the next issuer
+			var rateLimitErr *RateLimitError
+			if errors.As(err, &rateLimitErr) {
+				logging.LogStdOutWarn(fmt.Sprintf("%v Issuer [%v] is rate limiting, not retrying it", logPrefix, ref.Name))
+				if _, err := RecordIssuerRateLimit(config.Roadrunner.Config, rateLimitErr, time.Now()); err != nil {
+					logging.Check(err, fmt.Sprintf("%v Failed to write the backoff state of issuer [%v]", logPrefix, ref.Name))
+				}
+				break
+			}
+		}
+	}
+
+	return IssuedCertificate{}, fmt.Errorf("all issuers failed, last error: %w", lastErr)
+}
+
+// issueFromIssuer runs a single order for the certificate against one Issuer
+func (config Config) issueFromIssuer(ctx context.Context, logPrefix string, cert Certificate, issuer Issuer, certKey crypto.Signer, logger *zap.Logger) (IssuedCertificate, error) {
+	// Assemble the ConnectionInfo struct
+	cInfo := NewConnectionInfo(config.Roadrunner.Config, issuer)
+
+	// Set up the challenge solvers for the issuer type
+	solvers, err := config.challengeSolvers(issuer)
+	if err != nil {
+		return IssuedCertificate{}, err
+	}
+
+	// Create an ACME client
+	client, err := CreateACMEClient(cInfo, solvers, logger)
+	if err != nil {
+		return IssuedCertificate{}, fmt.Errorf("creating the ACME client: %v", err)
+	}
+
+	// Create a new Account
+	account, err := ACMEAccounts.Account(ctx, cert.Email, client, logger)
+	if err != nil {
+		return IssuedCertificate{}, asRateLimitError(issuer.Name, client, fmt.Errorf("creating the ACME client account: %w", err))
+	}
+	if account.Status != acme.StatusValid {
+		return IssuedCertificate{}, fmt.Errorf("ACME client account status is %v", account.Status)
+	}
+
+	// Every certificate needs a key, reuse the existing one when asked to
+	certPrivateKey := certKey
+	if certPrivateKey == nil {
+		certPrivateKey, err = NewCertificateKey(cert.RequestOptions)
+		if err != nil {
+			return IssuedCertificate{}, fmt.Errorf("generating certificate key: %v", err)
+		}
+	}
+
+	// Request a specific validity period if one is configured
+	var notBefore, notAfter *time.Time
+	if cert.RequestOptions.Expiration > 0 {
+		now := time.Now().UTC().Truncate(time.Second)
+		requestedNotAfter := now.Add(time.Duration(cert.RequestOptions.Expiration) * 24 * time.Hour)
+		notBefore, notAfter = &now, &requestedNotAfter
+	}
+
+	// Once your client, account, and certificate key are all ready,
+	// it's time to request a certificate!
+	// Name the live certificate in the order so the CA knows it is being replaced
+	replacement := config.orderReplacement(ctx, cert, issuer, client)
+	if replacement != nil {
+		logging.LogStdOutInfo(fmt.Sprintf("%v Ordering a replacement for certificate %v", logPrefix, replacement.CertID))
+	}
+
+	order := NewOrder(cert.Domains, notBefore, notAfter)
+	certs, err := ObtainCertificateWithOrder(ctx, client, account, order, replacement, certPrivateKey)
+	if err != nil {
+		return IssuedCertificate{}, asRateLimitError(issuer.Name, client, fmt.Errorf("obtaining certificate: %w", err))
+	}
+
+	// ACME servers may offer alternate chains, pick the preferred one
+	// and fall back to the default chain if none match
+	preferredChain := cert.PreferredChain
+	if preferredChain == "" {
+		preferredChain = issuer.PreferredChain
+	}
+	selectedChain, err := SelectPreferredChain(certs, preferredChain)
+	if err != nil {
+		return IssuedCertificate{}, err
+	}
+
+	// Verify the CA honoured the requested validity period
+	if notAfter != nil {
+		if err := checkValidityPeriod(logPrefix, selectedChain.ChainPEM, *notAfter); err != nil {
+			return IssuedCertificate{}, err
+		}
+	}
+
+	return IssuedCertificate{
+		Issuer:        issuer.Name,
+		Endpoint:      issuer.Endpoint,
+		Chain:         selectedChain,
+		PrivateKey:    certPrivateKey,
+		OfferedChains: len(certs),
+	}, nil
+}
+
+// challengeSolvers returns the challenge solvers for the Issuer type
+func (config Config) challengeSolvers(issuer Issuer) (map[string]acmez.Solver, error) {
+	switch issuer.Type {
+	case "http-01":
+		if err := HTTP01ChallengeSolver.Start(config.Roadrunner.Config); err != nil {
+			return nil, err
+		}
+		return map[string]acmez.Solver{
+			acme.ChallengeTypeHTTP01: HTTP01ChallengeSolver,
+		}, nil
+	default:
+		return map[string]acmez.Solver{
+			acme.ChallengeTypeHTTP01:    mySolver{}, // provide these!
+			acme.ChallengeTypeDNS01:     mySolver{}, // provide these!
+			acme.ChallengeTypeTLSALPN01: mySolver{}, // provide these!
+		}, nil
+	}
+}
+
+// checkValidityPeriod compares the issued certificate NotAfter with the requested one
+// and warns when the CA has shortened or otherwise changed the validity period
+func checkValidityPeriod(logPrefix string, chainPEM []byte, requestedNotAfter time.Time) error {
+	certs, err := parseCertificateChain(chainPEM)
+	if err != nil {
+		return err
+	}
+	leaf := certs[0]
+
+	difference := leaf.NotAfter.Sub(requestedNotAfter)
+	switch {
+	case difference < -ValidityPeriodTolerance:
+		logging.LogStdOutWarn(fmt.Sprintf("%v CA shortened the certificate validity, requested NotAfter %v but got %v", logPrefix, requestedNotAfter.Format(time.RFC3339), leaf.NotAfter.UTC().Format(time.RFC3339)))
+	case difference > ValidityPeriodTolerance:
+		logging.LogStdOutWarn(fmt.Sprintf("%v CA did not honour the requested validity, requested NotAfter %v but got %v", logPrefix, requestedNotAfter.Format(time.RFC3339), leaf.NotAfter.UTC().Format(time.RFC3339)))
+	default:
+		logging.LogStdOutInfo(fmt.Sprintf("%v Issued certificate matches the requested NotAfter %v", logPrefix, requestedNotAfter.Format(time.RFC3339)))
+	}
+
+	return nil
+}
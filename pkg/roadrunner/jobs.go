@@ -0,0 +1,178 @@
+package roadrunner
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kenmoini/roadrunner/internal/logging"
+)
+
+// JobStatus is the state of an admin job
+type JobStatus string
+
+const (
+	// JobPending means the job is waiting to run
+	JobPending JobStatus = "pending"
+	// JobRunning means the job is in progress
+	JobRunning JobStatus = "running"
+	// JobSucceeded means the job finished without error
+	JobSucceeded JobStatus = "succeeded"
+	// JobFailed means the job finished with an error
+	JobFailed JobStatus = "failed"
+)
+
+// Job is an admin action run in the background, polled through the API
+type Job struct {
+	// ID identifies the job in the API
+	ID string `json:"id"`
+	// Type is the action, eg renew, reissue, revoke or reload
+	Type string `json:"type"`
+	// Certificate is the name of the certificate the job acts on, if any
+	Certificate string `json:"certificate,omitempty"`
+	// Status is the current state of the job
+	Status JobStatus `json:"status"`
+	// Result is the certificate result of a finished renew or reissue job
+	Result CertificateResult `json:"result,omitempty"`
+	// Error is the error from a failed job
+	Error string `json:"error,omitempty"`
+	// CreatedAt, StartedAt and FinishedAt track the progress of the job
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// JobFunc is the work done by a job
+type JobFunc func(ctx context.Context) (CertificateResult, error)
+
+// JobManager runs admin jobs in the background and keeps the most recent ones for polling
+type JobManager struct {
+	mu   sync.RWMutex
+	jobs map[string]*Job
+	// queue is the order jobs were submitted in, oldest first
+	queue []string
+}
+
+// NewJobManager creates an empty JobManager
+func NewJobManager() *JobManager {
+	return &JobManager{jobs: map[string]*Job{}}
+}
+
+// Submit queues the job and runs it in the background until done or the context is cancelled
+func (m *JobManager) Submit(ctx context.Context, jobType string, certName string, work JobFunc) (Job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return Job{}, err
+	}
+
+	job := &Job{
+		ID:          id,
+		Type:        jobType,
+		Certificate: certName,
+		Status:      JobPending,
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	m.mu.Lock()
+	m.jobs[id] = job
+	m.queue = append(m.queue, id)
+	m.pruneLocked()
+	submitted := *job
+	m.mu.Unlock()
+
+	logging.LogStdOutInfo(fmt.Sprintf("[job %v] Queued %v job for [%v]", id, jobType, certName))
+	go m.runJob(ctx, job, work)
+
+	return submitted, nil
+}
+
+// runJob runs a single job and records the outcome
+func (m *JobManager) runJob(ctx context.Context, job *Job, work JobFunc) {
+	m.update(job, func(job *Job) {
+		now := time.Now().UTC()
+		job.Status = JobRunning
+		job.StartedAt = &now
+	})
+	logging.LogStdOutInfo(fmt.Sprintf("[job %v] Running %v job for [%v]", job.ID, job.Type, job.Certificate))
+
+	var result CertificateResult
+	err := ctx.Err()
+	if err == nil {
+		result, err = work(ctx)
+	}
+
+	m.update(job, func(job *Job) {
+		now := time.Now().UTC()
+		job.FinishedAt = &now
+		job.Result = result
+		job.Status = JobSucceeded
+		if err != nil {
+			job.Status = JobFailed
+			job.Error = err.Error()
+		}
+	})
+
+	if err != nil {
+		logging.LogStdOutWarn(fmt.Sprintf("[job %v] %v job for [%v] failed: %v", job.ID, job.Type, job.Certificate, err))
+		return
+	}
+	logging.LogStdOutInfo(fmt.Sprintf("[job %v] %v job for [%v] succeeded", job.ID, job.Type, job.Certificate))
+}
+
+// update changes a job under the lock
+func (m *JobManager) update(job *Job, change func(job *Job)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	change(job)
+}
+
+// Get returns a copy of the job with the ID
+func (m *JobManager) Get(id string) (Job, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// List returns copies of the retained jobs, newest first
+func (m *JobManager) List() []Job {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	jobs := []Job{}
+	for _, id := range m.queue {
+		jobs = append(jobs, *m.jobs[id])
+	}
+	sort.SliceStable(jobs, func(i, j int) bool { return jobs[i].CreatedAt.After(jobs[j].CreatedAt) })
+	return jobs
+}
+
+// pruneLocked drops the oldest finished jobs once more than MaxRetainedJobs are kept
+func (m *JobManager) pruneLocked() {
+	for i := 0; len(m.queue) > MaxRetainedJobs && i < len(m.queue); {
+		id := m.queue[i]
+		if status := m.jobs[id].Status; status == JobSucceeded || status == JobFailed {
+			delete(m.jobs, id)
+			m.queue = append(m.queue[:i], m.queue[i+1:]...)
+			continue
+		}
+		i++
+	}
+}
+
+// newJobID returns a random job ID
+func newJobID() (string, error) {
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(idBytes), nil
+}
@@ -0,0 +1,185 @@
+package roadrunner
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/mholt/acmez"
+	"github.com/mholt/acmez/acme"
+)
+
+// replacingOrder is a new order request with the RFC 9773 replaces field, which acme.Order doesn't have
+type replacingOrder struct {
+	Identifiers []acme.Identifier `json:"identifiers"`
+	NotBefore   string            `json:"notBefore,omitempty"`
+	NotAfter    string            `json:"notAfter,omitempty"`
+	Replaces    string            `json:"replaces"`
+}
+
+// NewReplacingOrder creates an order that tells the CA which certificate it replaces
+// The request is signed here because acmez doesn't support the replaces field
+func NewReplacingOrder(ctx context.Context, client acmez.Client, account acme.Account, directory ACMEDirectory, order acme.Order, replaces string) (acme.Order, error) {
+	request := replacingOrder{
+		Identifiers: order.Identifiers,
+		Replaces:    replaces,
+	}
+	if order.NotBefore != nil {
+		request.NotBefore = order.NotBefore.Format(time.RFC3339)
+	}
+	if order.NotAfter != nil {
+		request.NotAfter = order.NotAfter.Format(time.RFC3339)
+	}
+
+	resp, body, err := postJWS(ctx, client, account, directory, directory.NewOrder, request)
+	if err != nil {
+		return acme.Order{}, err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return acme.Order{}, fmt.Errorf("creating the order: HTTP %d", resp.StatusCode)
+	}
+
+	created := acme.Order{}
+	if err := json.Unmarshal(body, &created); err != nil {
+		return acme.Order{}, fmt.Errorf("decoding the order: %v", err)
+	}
+	created.Location = resp.Header.Get("Location")
+	return created, nil
+}
+
+// postJWS sends a JWS signed POST with the account key ID, retrying once on a bad nonce
+// Problem documents are returned as acme.Problem errors
+func postJWS(ctx context.Context, client acmez.Client, account acme.Account, directory ACMEDirectory, url string, payload interface{}) (*http.Response, []byte, error) {
+	nonce, err := fetchNonce(ctx, client, directory)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		signed, err := signJWS(payload, account.PrivateKey, account.Location, nonce, url)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(signed))
+		if err != nil {
+			return nil, nil, err
+		}
+		req.Header.Set("Content-Type", "application/jose+json")
+		resp, err := acmeHTTPClient(client).Do(req)
+		if err != nil {
+			return nil, nil, err
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if resp.StatusCode < 400 {
+			return resp, body, nil
+		}
+
+		problem := acme.Problem{Status: resp.StatusCode}
+		if err := json.Unmarshal(body, &problem); err != nil {
+			return resp, body, fmt.Errorf("HTTP %d: %s", resp.StatusCode, body)
+		}
+		if problem.Type == acme.ProblemTypeBadNonce && attempt == 0 && resp.Header.Get("Replay-Nonce") != "" {
+			nonce = resp.Header.Get("Replay-Nonce")
+			continue
+		}
+		return resp, body, problem
+	}
+}
+
+// fetchNonce gets a fresh anti-replay nonce from the CA
+func fetchNonce(ctx context.Context, client acmez.Client, directory ACMEDirectory) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, directory.NewNonce, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := acmeHTTPClient(client).Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	nonce := resp.Header.Get("Replay-Nonce")
+	if nonce == "" {
+		return "", fmt.Errorf("the CA did not return a nonce")
+	}
+	return nonce, nil
+}
+
+// signJWS produces a flattened JWS of the payload signed by the account key
+func signJWS(payload interface{}, key crypto.Signer, kid string, nonce string, url string) ([]byte, error) {
+	if key == nil || kid == "" {
+		return nil, fmt.Errorf("an account key and location are needed to sign requests")
+	}
+
+	var alg string
+	var hash crypto.Hash
+	switch pub := key.Public().(type) {
+	case *rsa.PublicKey:
+		alg, hash = "RS256", crypto.SHA256
+	case *ecdsa.PublicKey:
+		switch pub.Params().Name {
+		case "P-256":
+			alg, hash = "ES256", crypto.SHA256
+		case "P-384":
+			alg, hash = "ES384", crypto.SHA384
+		case "P-521":
+			alg, hash = "ES512", crypto.SHA512
+		}
+	}
+	if alg == "" {
+		return nil, fmt.Errorf("unsupported account key type %T", key.Public())
+	}
+
+	protected, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "nonce": nonce, "url": url})
+	if err != nil {
+		return nil, err
+	}
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	encodedProtected := base64.RawURLEncoding.EncodeToString(protected)
+	encodedPayload := base64.RawURLEncoding.EncodeToString(payloadJSON)
+
+	digest := hash.New()
+	digest.Write([]byte(encodedProtected + "." + encodedPayload))
+
+	var signature []byte
+	if ecKey, ok := key.(*ecdsa.PrivateKey); ok {
+		// JWS wants the raw R and S values rather than the ASN.1 signature ecdsa.PrivateKey.Sign returns
+		r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest.Sum(nil))
+		if err != nil {
+			return nil, err
+		}
+		size := (ecKey.Params().BitSize + 7) / 8
+		signature = make([]byte, size*2)
+		r.FillBytes(signature[:size])
+		s.FillBytes(signature[size:])
+	} else {
+		signature, err = key.Sign(rand.Reader, digest.Sum(nil), hash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(map[string]string{
+		"protected": encodedProtected,
+		"payload":   encodedPayload,
+		"signature": base64.RawURLEncoding.EncodeToString(signature),
+	})
+}
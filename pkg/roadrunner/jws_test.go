@@ -0,0 +1,167 @@
+package roadrunner
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mholt/acmez/acme"
+)
+
+const testCertID = "aYhba4dGQEHhs3uEe6CuLN4ByNQ.AIdlQyE"
+
+func TestNewReplacingOrder(t *testing.T) {
+	keys := map[string]func() (crypto.Signer, error){
+		"ES256": func() (crypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P256(), rand.Reader) },
+		"ES384": func() (crypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P384(), rand.Reader) },
+		"ES512": func() (crypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P521(), rand.Reader) },
+		"RS256": func() (crypto.Signer, error) { return rsa.GenerateKey(rand.Reader, 2048) },
+	}
+	for alg, generate := range keys {
+		t.Run(alg, func(t *testing.T) {
+			key, err := generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			f := newFakeACME(t, key)
+			ctx := context.Background()
+			client := f.client()
+			directory, err := FetchACMEDirectory(ctx, client)
+			if err != nil {
+				t.Fatalf("FetchACMEDirectory: %v", err)
+			}
+
+			notAfter := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+			order := NewOrder([]string{"example.test", "192.0.2.1"}, nil, &notAfter)
+			created, err := NewReplacingOrder(ctx, client, f.account(key), directory, order, testCertID)
+			if err != nil {
+				t.Fatalf("NewReplacingOrder: %v", err)
+			}
+			if created.Location != f.server.URL+"/order/1" || created.Status != acme.StatusPending || len(created.Authorizations) != 1 {
+				t.Errorf("created order = %+v", created)
+			}
+
+			if f.orderCount() != 1 {
+				t.Fatalf("the server got %d orders, want 1", f.orderCount())
+			}
+			payload := f.orders[0]
+			if payload["replaces"] != testCertID {
+				t.Errorf("replaces = %v, want %v", payload["replaces"], testCertID)
+			}
+			if payload["notAfter"] != "2030-01-02T03:04:05Z" {
+				t.Errorf("notAfter = %v, want 2030-01-02T03:04:05Z", payload["notAfter"])
+			}
+			if _, ok := payload["notBefore"]; ok {
+				t.Errorf("notBefore = %v, want it left out", payload["notBefore"])
+			}
+			identifiers, _ := payload["identifiers"].([]interface{})
+			if len(identifiers) != 2 {
+				t.Fatalf("identifiers = %v, want 2", payload["identifiers"])
+			}
+			if id := identifiers[1].(map[string]interface{}); id["type"] != "ip" || id["value"] != "192.0.2.1" {
+				t.Errorf("second identifier = %v, want the IP address", id)
+			}
+		})
+	}
+}
+
+func TestNewReplacingOrderBadNonce(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ctx := context.Background()
+
+	// A badNonce is retried once with the nonce that came with the problem
+	f := newFakeACME(t, key)
+	f.badNonces = 1
+	client := f.client()
+	directory, _ := FetchACMEDirectory(ctx, client)
+	if _, err := NewReplacingOrder(ctx, client, f.account(key), directory, NewOrder([]string{"example.test"}, nil, nil), testCertID); err != nil {
+		t.Fatalf("NewReplacingOrder after a bad nonce: %v", err)
+	}
+	if f.orderCount() != 1 {
+		t.Errorf("the server got %d orders, want 1", f.orderCount())
+	}
+
+	// But not twice
+	f = newFakeACME(t, key)
+	f.badNonces = 2
+	client = f.client()
+	directory, _ = FetchACMEDirectory(ctx, client)
+	_, err := NewReplacingOrder(ctx, client, f.account(key), directory, NewOrder([]string{"example.test"}, nil, nil), testCertID)
+	var problem acme.Problem
+	if !errors.As(err, &problem) || problem.Type != acme.ProblemTypeBadNonce {
+		t.Errorf("NewReplacingOrder after two bad nonces = %v, want a badNonce problem", err)
+	}
+}
+
+func TestNewOrderReplacement(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ctx := context.Background()
+
+	tests := []struct {
+		name           string
+		replacement    bool
+		rejectReplaces string
+		wantOrders     int
+		wantErr        bool
+	}{
+		{"without a replacement", false, "", 1, false},
+		{"with a replacement", true, "", 1, false},
+		// A CA that refuses the replaces field gets a plain order instead
+		{"already replaced", true, ProblemTypeAlreadyReplaced, 2, false},
+		{"replaces not understood", true, acme.ProblemTypeMalformed, 2, false},
+		{"other problems", true, acme.ProblemTypeUnauthorized, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeACME(t, key)
+			f.rejectReplaces = tt.rejectReplaces
+			client := f.client()
+
+			var replacement *OrderReplacement
+			if tt.replacement {
+				directory, err := FetchACMEDirectory(ctx, client)
+				if err != nil {
+					t.Fatal(err)
+				}
+				replacement = &OrderReplacement{Directory: directory, CertID: testCertID}
+			}
+
+			created, err := newOrder(ctx, client, f.account(key), NewOrder([]string{"example.test"}, nil, nil), replacement)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newOrder() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && created.Location == "" {
+				t.Errorf("created order has no location")
+			}
+			if f.orderCount() != tt.wantOrders {
+				t.Fatalf("the server got %d orders, want %d", f.orderCount(), tt.wantOrders)
+			}
+
+			_, replaced := f.orders[0]["replaces"]
+			if replaced != tt.replacement {
+				t.Errorf("first order replaces = %v, want %v", f.orders[0]["replaces"], tt.replacement)
+			}
+			if tt.wantOrders == 2 {
+				if _, ok := f.orders[1]["replaces"]; ok {
+					t.Errorf("the fallback order still names the replaced certificate")
+				}
+			}
+		})
+	}
+}
+
+func TestSignJWSNeedsAccount(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if _, err := signJWS(map[string]string{}, key, "", "nonce", "https://example.test/new-order"); err == nil {
+		t.Error("signJWS without a kid succeeded")
+	}
+	if _, err := signJWS(map[string]string{}, nil, "https://example.test/account/1", "nonce", "https://example.test/new-order"); err == nil {
+		t.Error("signJWS without a key succeeded")
+	}
+}
@@ -0,0 +1,88 @@
+package roadrunner
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"strings"
+)
+
+// KeySpec returns the normalised key type and size requested for a certificate
+func KeySpec(opts RequestOptions) (string, int) {
+	keyType := strings.ToLower(opts.KeyType)
+	switch keyType {
+	case "", "ec", "ecdsa":
+		keyType = "ecdsa"
+		if opts.KeySize == 0 {
+			return keyType, DefaultECDSAKeySize
+		}
+	case "rsa":
+		if opts.KeySize == 0 {
+			return keyType, DefaultRSAKeySize
+		}
+	}
+	return keyType, opts.KeySize
+}
+
+// ValidateKeySpec checks the requested key type and size are supported
+func ValidateKeySpec(opts RequestOptions) error {
+	keyType, keySize := KeySpec(opts)
+	switch keyType {
+	case "ecdsa":
+		switch keySize {
+		case 256, 384, 521:
+			return nil
+		}
+	case "rsa":
+		switch keySize {
+		case 2048, 3072, 4096, 8192:
+			return nil
+		}
+	default:
+		return fmt.Errorf("unknown key_type [%v]", opts.KeyType)
+	}
+	return fmt.Errorf("unsupported key_size %d for key_type %v", keySize, keyType)
+}
+
+// NewCertificateKey generates a certificate key of the requested type and size
+func NewCertificateKey(opts RequestOptions) (crypto.Signer, error) {
+	if err := ValidateKeySpec(opts); err != nil {
+		return nil, err
+	}
+
+	keyType, keySize := KeySpec(opts)
+	if keyType == "rsa" {
+		return rsa.GenerateKey(rand.Reader, keySize)
+	}
+
+	var curve elliptic.Curve
+	switch keySize {
+	case 384:
+		curve = elliptic.P384()
+	case 521:
+		curve = elliptic.P521()
+	default:
+		curve = elliptic.P256()
+	}
+	return ecdsa.GenerateKey(curve, rand.Reader)
+}
+
+// keySpecMatches reports if the key is of the type and size
+func keySpecMatches(key crypto.Signer, keyType string, keySize int) bool {
+	liveType, liveSize := PublicKeySpec(key.Public())
+	return liveType == keyType && liveSize == keySize
+}
+
+// PublicKeySpec returns the type and size of a public key, in the same form as KeySpec
+func PublicKeySpec(publicKey crypto.PublicKey) (string, int) {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return "rsa", key.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "ecdsa", key.Curve.Params().BitSize
+	}
+	return fmt.Sprintf("%T", publicKey), 0
+}
@@ -0,0 +1,125 @@
+package roadrunner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kenmoini/roadrunner/internal/logging"
+	"golang.org/x/sys/unix"
+)
+
+// WorkingDirLock is an exclusive flock on a working directory, held for as long as the process uses it
+type WorkingDirLock struct {
+	// Dir is the locked working directory
+	Dir  string
+	file *os.File
+}
+
+// LockWorkingDirectory takes the lock on the working directory so a second roadrunner, like a cron job
+// next to the daemon, can't work on the same .acme tree
+// When another process holds the lock it is retried until wait has passed, or fails straight away when wait is 0
+func LockWorkingDirectory(ctx context.Context, workingDir string, wait time.Duration) (*WorkingDirLock, error) {
+	if err := os.MkdirAll(workingDir, 0755); err != nil {
+		return nil, fmt.Errorf("creating working directory: %v", err)
+	}
+
+	path := workingDir + WorkingDirLockFile
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("opening the lock file: %v", err)
+	}
+
+	deadline := time.Now().Add(wait)
+	logged := false
+	for {
+		err = unix.Flock(int(file.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if err != unix.EWOULDBLOCK {
+			file.Close()
+			return nil, fmt.Errorf("locking %v: %v", path, err)
+		}
+
+		holder := lockHolder(file)
+		if !time.Now().Before(deadline) {
+			file.Close()
+			if wait > 0 {
+				return nil, fmt.Errorf("working directory [%v] is still in use by another roadrunner process%v after waiting %v", workingDir, holder, wait)
+			}
+			return nil, fmt.Errorf("working directory [%v] is in use by another roadrunner process%v, use -lock-wait to wait for it", workingDir, holder)
+		}
+		if !logged {
+			logging.LogStdOutInfo(fmt.Sprintf("Working directory [%v] is in use by another roadrunner process%v, waiting up to %v...", workingDir, holder, wait))
+			logged = true
+		}
+
+		select {
+		case <-time.After(WorkingDirLockPollInterval):
+		case <-ctx.Done():
+			file.Close()
+			return nil, ctx.Err()
+		}
+	}
+
+	// Record who holds the lock to help whoever runs into it
+	if err := file.Truncate(0); err == nil {
+		file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+
+	return &WorkingDirLock{Dir: workingDir, file: file}, nil
+}
+
+// lockHolder describes the process holding the lock from the PID it recorded, if any
+func lockHolder(file *os.File) string {
+	contents := make([]byte, 32)
+	n, _ := file.ReadAt(contents, 0)
+	pid := strings.TrimSpace(string(contents[:n]))
+	if pid == "" {
+		return ""
+	}
+	return " (pid " + pid + ")"
+}
+
+// Unlock releases the lock on the working directory
+func (l *WorkingDirLock) Unlock() {
+	if l == nil {
+		return
+	}
+	unix.Flock(int(l.file.Fd()), unix.LOCK_UN)
+	l.file.Close()
+}
+
+// CertificateLocks stops the scheduler and the admin API from working on the same certificate at the same time
+type CertificateLocks struct {
+	mu    sync.Mutex
+	locks map[string]chan struct{}
+}
+
+// NewCertificateLocks creates a CertificateLocks
+func NewCertificateLocks() *CertificateLocks {
+	return &CertificateLocks{locks: map[string]chan struct{}{}}
+}
+
+// Lock waits until no one else is working on the certificate and returns the function that releases it
+func (c *CertificateLocks) Lock(ctx context.Context, name string) (func(), error) {
+	c.mu.Lock()
+	lock, ok := c.locks[name]
+	if !ok {
+		lock = make(chan struct{}, 1)
+		c.locks[name] = lock
+	}
+	c.mu.Unlock()
+
+	select {
+	case lock <- struct{}{}:
+		return func() { <-lock }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,263 @@
+package roadrunner
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// acmeRequestBuckets are the histogram buckets for ACME request latency, in seconds
+var acmeRequestBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Metrics collects the values exported on the Prometheus /metrics endpoint
+type Metrics struct {
+	mu sync.Mutex
+
+	// Per certificate values, keyed by certificate name
+	notAfter    map[string]time.Time
+	lastAttempt map[string]time.Time
+	lastSuccess map[string]time.Time
+
+	// Counters keyed by issuer name
+	issuances map[string]float64
+	renewals  map[string]float64
+	failures  map[string]float64
+
+	// Challenge failures keyed by challenge type
+	challengeFailures map[string]float64
+
+	// ACME request latency keyed by issuer name
+	acmeRequests map[string]*histogram
+}
+
+// histogram is a minimal cumulative Prometheus histogram
+type histogram struct {
+	counts []float64
+	sum    float64
+	count  float64
+}
+
+// NewMetrics creates an empty Metrics collector
+func NewMetrics() *Metrics {
+	return &Metrics{
+		notAfter:          map[string]time.Time{},
+		lastAttempt:       map[string]time.Time{},
+		lastSuccess:       map[string]time.Time{},
+		issuances:         map[string]float64{},
+		renewals:          map[string]float64{},
+		failures:          map[string]float64{},
+		challengeFailures: map[string]float64{},
+		acmeRequests:      map[string]*histogram{},
+	}
+}
+
+// RecordExpiry records the NotAfter of the live certificate
+func (m *Metrics) RecordExpiry(name string, notAfter time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.notAfter[name] = notAfter
+}
+
+// RecordAttempt records that an issuance or renewal was attempted
+func (m *Metrics) RecordAttempt(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastAttempt[name] = time.Now()
+}
+
+// RecordSuccess records a successful issuance or renewal by an issuer
+func (m *Metrics) RecordSuccess(name string, issuer string, renewed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastSuccess[name] = time.Now()
+	if renewed {
+		m.renewals[issuer]++
+	} else {
+		m.issuances[issuer]++
+	}
+}
+
+// RecordFailure records a failed attempt against an issuer
+func (m *Metrics) RecordFailure(issuer string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failures[issuer]++
+}
+
+// RecordChallengeFailure records a failed challenge of the given type
+func (m *Metrics) RecordChallengeFailure(challengeType string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.challengeFailures[challengeType]++
+}
+
+// RecordACMERequest records the latency of a request to an issuer's ACME server
+func (m *Metrics) RecordACMERequest(issuer string, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.acmeRequests[issuer]
+	if !ok {
+		h = &histogram{counts: make([]float64, len(acmeRequestBuckets))}
+		m.acmeRequests[issuer] = h
+	}
+	seconds := duration.Seconds()
+	for i, bound := range acmeRequestBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// RetainCertificates drops the per certificate values for certificates that are no longer configured
+func (m *Metrics) RetainCertificates(names []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keep := map[string]bool{}
+	for _, name := range names {
+		keep[name] = true
+	}
+	for _, values := range []map[string]time.Time{m.notAfter, m.lastAttempt, m.lastSuccess} {
+		for name := range values {
+			if !keep[name] {
+				delete(values, name)
+			}
+		}
+	}
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	now := time.Now()
+
+	writeHeader(&b, "roadrunner_certificate_expiry_seconds", "gauge", "Seconds until the live certificate expires.")
+	for _, name := range sortedKeys(m.notAfter) {
+		fmt.Fprintf(&b, "roadrunner_certificate_expiry_seconds{certificate=\"%v\"} %v\n", escapeLabel(name), m.notAfter[name].Sub(now).Seconds())
+	}
+
+	writeHeader(&b, "roadrunner_certificate_last_renewal_attempt_timestamp_seconds", "gauge", "Unix time of the last issuance or renewal attempt.")
+	for _, name := range sortedKeys(m.lastAttempt) {
+		fmt.Fprintf(&b, "roadrunner_certificate_last_renewal_attempt_timestamp_seconds{certificate=\"%v\"} %v\n", escapeLabel(name), m.lastAttempt[name].Unix())
+	}
+
+	writeHeader(&b, "roadrunner_certificate_last_renewal_success_timestamp_seconds", "gauge", "Unix time of the last successful issuance or renewal.")
+	for _, name := range sortedKeys(m.lastSuccess) {
+		fmt.Fprintf(&b, "roadrunner_certificate_last_renewal_success_timestamp_seconds{certificate=\"%v\"} %v\n", escapeLabel(name), m.lastSuccess[name].Unix())
+	}
+
+	writeCounter(&b, "roadrunner_issuances_total", "Certificates issued, by issuer.", "issuer", m.issuances)
+	writeCounter(&b, "roadrunner_renewals_total", "Certificates renewed, by issuer.", "issuer", m.renewals)
+	writeCounter(&b, "roadrunner_failures_total", "Failed issuance or renewal attempts, by issuer.", "issuer", m.failures)
+	writeCounter(&b, "roadrunner_challenge_failures_total", "Failed ACME challenges, by challenge type.", "type", m.challengeFailures)
+
+	writeHeader(&b, "roadrunner_acme_request_duration_seconds", "histogram", "Latency of requests to the ACME servers, by issuer.")
+	for _, issuer := range sortedKeys(m.acmeRequests) {
+		h := m.acmeRequests[issuer]
+		for i, bound := range acmeRequestBuckets {
+			fmt.Fprintf(&b, "roadrunner_acme_request_duration_seconds_bucket{issuer=\"%v\",le=\"%v\"} %v\n", escapeLabel(issuer), bound, h.counts[i])
+		}
+		fmt.Fprintf(&b, "roadrunner_acme_request_duration_seconds_bucket{issuer=\"%v\",le=\"+Inf\"} %v\n", escapeLabel(issuer), h.count)
+		fmt.Fprintf(&b, "roadrunner_acme_request_duration_seconds_sum{issuer=\"%v\"} %v\n", escapeLabel(issuer), h.sum)
+		fmt.Fprintf(&b, "roadrunner_acme_request_duration_seconds_count{issuer=\"%v\"} %v\n", escapeLabel(issuer), h.count)
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// ServeHTTP serves the metrics for Prometheus to scrape
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// instrumentedTransport records the latency of every ACME request made through it,
+// along with the last Retry-After the CA sent back with an error
+type instrumentedTransport struct {
+	issuer    string
+	transport http.RoundTripper
+	metrics   *Metrics
+
+	mu         sync.Mutex
+	retryAfter time.Time
+}
+
+// RoundTrip times the request and records it against the issuer
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.transport.RoundTrip(req)
+	t.metrics.RecordACMERequest(t.issuer, time.Since(start))
+
+	// Only the latest response counts, an earlier failure that was retried successfully says nothing
+	// about the request that failed last
+	var retryAfter time.Time
+	if err == nil && resp.StatusCode >= 400 {
+		retryAfter, _ = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	}
+	t.mu.Lock()
+	t.retryAfter = retryAfter
+	t.mu.Unlock()
+	return resp, err
+}
+
+// RetryAfter returns when the CA asked to be retried in its latest response, or the zero time if it didn't
+func (t *instrumentedTransport) RetryAfter() time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.retryAfter
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return now.Add(time.Duration(seconds) * time.Second), true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return date, true
+	}
+	return time.Time{}, false
+}
+
+// writeHeader writes the HELP and TYPE lines for a metric
+func writeHeader(b *strings.Builder, name string, metricType string, help string) {
+	fmt.Fprintf(b, "# HELP %v %v\n# TYPE %v %v\n", name, help, name, metricType)
+}
+
+// writeCounter writes a counter with a single label
+func writeCounter(b *strings.Builder, name string, help string, label string, values map[string]float64) {
+	writeHeader(b, name, "counter", help)
+	for _, key := range sortedKeys(values) {
+		fmt.Fprintf(b, "%v{%v=\"%v\"} %v\n", name, label, escapeLabel(key), values[key])
+	}
+}
+
+// escapeLabel escapes a Prometheus label value
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// sortedKeys returns the keys of a map in a stable order
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,98 @@
+package roadrunner
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestInstrumentedTransportRetryAfter(t *testing.T) {
+	// Each request path answers with the status and Retry-After in the table below
+	responses := map[string]struct {
+		status     int
+		retryAfter string
+	}{
+		"/busy":     {http.StatusServiceUnavailable, "120"},
+		"/ok":       {http.StatusOK, ""},
+		"/bad":      {http.StatusBadRequest, ""},
+		"/limited":  {http.StatusTooManyRequests, "60"},
+		"/redirect": {http.StatusOK, "30"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response := responses[r.URL.Path]
+		if response.retryAfter != "" {
+			w.Header().Set("Retry-After", response.retryAfter)
+		}
+		w.WriteHeader(response.status)
+	}))
+	defer server.Close()
+
+	transport := &instrumentedTransport{issuer: "test", transport: http.DefaultTransport, metrics: NewMetrics()}
+	client := &http.Client{Transport: transport}
+
+	tests := []struct {
+		path string
+		want time.Duration
+	}{
+		{"/busy", 120 * time.Second},
+		// A later success clears the earlier Retry-After
+		{"/ok", 0},
+		{"/busy", 120 * time.Second},
+		// So does a later, unrelated failure without one
+		{"/bad", 0},
+		{"/limited", 60 * time.Second},
+		// Retry-After on a success isn't a request to back off
+		{"/redirect", 0},
+	}
+	for _, tt := range tests {
+		before := time.Now()
+		resp, err := client.Get(server.URL + tt.path)
+		if err != nil {
+			t.Fatalf("GET %v: %v", tt.path, err)
+		}
+		resp.Body.Close()
+
+		got := transport.RetryAfter()
+		if tt.want == 0 {
+			if !got.IsZero() {
+				t.Errorf("after GET %v RetryAfter() = %v, want zero", tt.path, got)
+			}
+			continue
+		}
+		if got.Before(before.Add(tt.want)) || got.After(time.Now().Add(tt.want)) {
+			t.Errorf("after GET %v RetryAfter() = %v, want %v from now", tt.path, got, tt.want)
+		}
+	}
+
+	// A request that never got a response clears it too
+	server.Close()
+	if _, err := client.Get(server.URL + "/busy"); err == nil {
+		t.Fatal("GET on a closed server succeeded")
+	}
+	if got := transport.RetryAfter(); !got.IsZero() {
+		t.Errorf("after a failed connection RetryAfter() = %v, want zero", got)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		value  string
+		want   time.Time
+		wantOK bool
+	}{
+		{"", time.Time{}, false},
+		{"30", now.Add(30 * time.Second), true},
+		{" 0 ", now, true},
+		{"-5", time.Time{}, false},
+		{"Fri, 02 Jan 2026 04:00:00 GMT", time.Date(2026, 1, 2, 4, 0, 0, 0, time.UTC), true},
+		{"soon", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value, now)
+		if ok != tt.wantOK || !got.Equal(tt.want) {
+			t.Errorf("parseRetryAfter(%q) = %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
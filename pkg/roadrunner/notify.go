@@ -0,0 +1,433 @@
+package roadrunner
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kenmoini/roadrunner/internal/helpers"
+	"github.com/kenmoini/roadrunner/internal/logging"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// EventIssued is sent when a certificate is issued for the first time
+	EventIssued = "issued"
+	// EventRenewed is sent when a certificate is renewed or reissued
+	EventRenewed = "renewed"
+	// EventFailed is sent when a certificate fails to process, including while it is backing off
+	EventFailed = "failed"
+	// EventExpiring is sent on every check while a certificate is overdue for renewal and close to expiry
+	EventExpiring = "expiring"
+)
+
+// EventTypes are the events notifiers can filter on
+var EventTypes = []string{EventIssued, EventRenewed, EventFailed, EventExpiring}
+
+// Notifier sends events about the certificates somewhere people will see them
+type Notifier struct {
+	// Name identifies the notifier in the logs
+	Name string `yaml:"name"`
+	// Type is the kind of notifier, "webhook" (default) or "smtp"
+	Type string `yaml:"type,omitempty"`
+	// Events limits the events sent to these, defaults to all of them for webhooks and failed and expiring for email
+	Events []string `yaml:"events,omitempty"`
+	// ExpiryWarningDays is how many days before expiry an overdue certificate is warned about, defaults to 14
+	ExpiryWarningDays int `yaml:"expiry_warning_days,omitempty"`
+	// Retries is how many times a failed delivery is retried, defaults to 3
+	Retries *int `yaml:"retries,omitempty"`
+	// Timeout bounds each delivery attempt, defaults to 10s
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// RateLimit is the least time between two messages about the same certificate and event,
+	// defaults to 24h for email and 1h for webhooks, 0 turns it off
+	RateLimit *time.Duration `yaml:"rate_limit,omitempty"`
+
+	// URL is where webhook events are POSTed
+	URL string `yaml:"url,omitempty"`
+	// Format is the webhook payload, "json" for the event itself, or "slack" or "teams" for a chat message
+	Format string `yaml:"format,omitempty"`
+	// Secret signs webhook payloads with HMAC-SHA256 in the X-Roadrunner-Signature header
+	Secret string `yaml:"secret,omitempty"`
+	// Headers are extra headers sent with webhook requests, eg an Authorization header
+	Headers map[string]string `yaml:"headers,omitempty"`
+
+	// Server is the SMTP server as host or host:port, the port defaults to 587, or 465 with implicit TLS
+	Server string `yaml:"server,omitempty"`
+	// TLS is how the SMTP connection is secured, "starttls" (default), "implicit" or "none"
+	TLS string `yaml:"tls,omitempty"`
+	// Username and Password authenticate with the SMTP server, which is only done over TLS or to localhost
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	// From is the sender address of the emails
+	From string `yaml:"from,omitempty"`
+	// To are the recipients of the emails, defaults to the email of each certificate
+	To []string `yaml:"to,omitempty"`
+}
+
+// Event is something that happened to a certificate
+type Event struct {
+	// Type is one of the EventTypes
+	Type        string     `json:"event"`
+	Certificate string     `json:"certificate"`
+	Domains     []string   `json:"domains"`
+	Issuer      string     `json:"issuer,omitempty"`
+	NotAfter    *time.Time `json:"not_after,omitempty"`
+	// DaysRemaining is how many whole days are left until NotAfter
+	DaysRemaining *int   `json:"days_remaining,omitempty"`
+	Error         string `json:"error,omitempty"`
+	// Failures is how many times in a row the certificate has failed
+	Failures int `json:"failures,omitempty"`
+	// Email is the contact address of the certificate, used as the default email recipient
+	Email string `json:"-"`
+	// Host is the machine roadrunner is running on
+	Host string    `json:"host"`
+	Time time.Time `json:"time"`
+}
+
+// Summary describes the event in a sentence for chat messages and logs
+func (event Event) Summary() string {
+	domains := strings.Join(event.Domains, ", ")
+	switch event.Type {
+	case EventIssued:
+		return fmt.Sprintf("Certificate %v was issued for %v%v", event.Certificate, domains, event.validUntil())
+	case EventRenewed:
+		return fmt.Sprintf("Certificate %v was renewed for %v%v", event.Certificate, domains, event.validUntil())
+	case EventFailed:
+		return fmt.Sprintf("Certificate %v for %v failed on %v%v: %v", event.Certificate, domains, event.Host, event.expiresIn(), event.Error)
+	case EventExpiring:
+		if event.DaysRemaining != nil && *event.DaysRemaining < 0 {
+			return fmt.Sprintf("Certificate %v for %v on %v has expired and has not been renewed", event.Certificate, domains, event.Host)
+		}
+		return fmt.Sprintf("Certificate %v for %v on %v expires in %d days and has not been renewed", event.Certificate, domains, event.Host, *event.DaysRemaining)
+	}
+	return fmt.Sprintf("Certificate %v: %v", event.Certificate, event.Type)
+}
+
+// validUntil describes the NotAfter of a new certificate
+func (event Event) validUntil() string {
+	if event.NotAfter == nil {
+		return ""
+	}
+	return ", valid until " + event.NotAfter.UTC().Format(time.RFC3339)
+}
+
+// expiresIn describes how long the live certificate has left
+func (event Event) expiresIn() string {
+	if event.DaysRemaining == nil {
+		return ""
+	}
+	if *event.DaysRemaining < 0 {
+		return " (expired)"
+	}
+	return fmt.Sprintf(" (expires in %d days)", *event.DaysRemaining)
+}
+
+// Validate checks the notifier can be used
+func (notifier Notifier) Validate() error {
+	switch notifier.Type {
+	case "", "webhook":
+		if notifier.URL == "" {
+			return fmt.Errorf("webhook notifier needs a url")
+		}
+		switch notifier.Format {
+		case "", "json", "slack", "teams":
+		default:
+			return fmt.Errorf("unknown webhook format [%v], options are json, slack and teams", notifier.Format)
+		}
+	case "smtp":
+		if err := notifier.validateSMTP(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown notifier type [%v]", notifier.Type)
+	}
+	for _, event := range notifier.Events {
+		if !slices.Contains(EventTypes, event) {
+			return fmt.Errorf("unknown event [%v], options are %v", event, strings.Join(EventTypes, ", "))
+		}
+	}
+	if notifier.Retries != nil && *notifier.Retries < 0 {
+		return fmt.Errorf("retries can't be negative")
+	}
+	if notifier.ExpiryWarningDays < 0 || notifier.Timeout < 0 || (notifier.RateLimit != nil && *notifier.RateLimit < 0) {
+		return fmt.Errorf("expiry_warning_days, timeout and rate_limit can't be negative")
+	}
+	return nil
+}
+
+// Wants reports if the notifier sends the event
+func (notifier Notifier) Wants(event Event) bool {
+	events := notifier.Events
+	if len(events) == 0 && notifier.Type == "smtp" {
+		// Email is for things someone has to act on
+		events = []string{EventFailed, EventExpiring}
+	}
+	if len(events) > 0 && !slices.Contains(events, event.Type) {
+		return false
+	}
+	if event.Type == EventExpiring {
+		return notifier.expiringSoon(event)
+	}
+	return true
+}
+
+// expiringSoon reports if the certificate of the event is within the expiry warning days
+func (notifier Notifier) expiringSoon(event Event) bool {
+	warningDays := notifier.ExpiryWarningDays
+	if warningDays == 0 {
+		warningDays = DefaultExpiryWarningDays
+	}
+	return event.DaysRemaining != nil && *event.DaysRemaining < warningDays
+}
+
+// rateLimit is the least time between two messages about the same certificate and event
+func (notifier Notifier) rateLimit() time.Duration {
+	if notifier.RateLimit != nil {
+		return *notifier.RateLimit
+	}
+	if notifier.Type == "smtp" {
+		return DefaultEmailRateLimit
+	}
+	return DefaultWebhookRateLimit
+}
+
+// send delivers the event with the notifier, retrying failed deliveries
+func (notifier Notifier) send(ctx context.Context, appConfig AppConfig, event Event) error {
+	retries := DefaultNotifierRetries
+	if notifier.Retries != nil {
+		retries = *notifier.Retries
+	}
+
+	var err error
+	for attempt := 0; attempt <= retries; attempt++ {
+		var retryable bool
+		var retryAt time.Time
+		if notifier.Type == "smtp" {
+			retryable, retryAt, err = notifier.sendSMTP(ctx, appConfig, event)
+		} else {
+			retryable, retryAt, err = notifier.sendWebhook(ctx, appConfig, event)
+		}
+		if err == nil || !retryable || attempt == retries {
+			break
+		}
+		if retryAt.IsZero() {
+			retryAt = time.Now().Add(NotifierRetryDelay * time.Duration(math.Pow(2, float64(attempt))))
+		}
+		// Notify is waited for by the check, so a receiver asking for a long wait isn't obliged
+		if time.Until(retryAt) > MaxNotifierRetryDelay {
+			logging.LogStdOutWarn(fmt.Sprintf("[notifier %v] Delivery failed and the receiver asked to retry at %v, giving up: %v", notifier.Name, retryAt.Format(time.RFC3339), err))
+			break
+		}
+		logging.LogStdOutWarn(fmt.Sprintf("[notifier %v] Delivery failed, retrying at %v: %v", notifier.Name, retryAt.Format(time.RFC3339), err))
+		select {
+		case <-time.After(time.Until(retryAt)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+// CertificateEvents returns the events for the outcome of processing a certificate
+// The live certificate is read for its NotAfter, the issuer that produced it, how long it has left
+// and when it was due for renewal
+func (config Config) CertificateEvents(cert Certificate, result CertificateResult, err error) []Event {
+	basePath := config.Roadrunner.Config.WorkingDir
+	now := time.Now()
+	host, _ := os.Hostname()
+	base := Event{
+		Certificate: cert.ID(),
+		Domains:     cert.Domains,
+		Email:       cert.Email,
+		Host:        host,
+		Time:        now.UTC(),
+	}
+
+	var renewAt *time.Time
+	if liveCert, loadErr := LoadLiveCertificate(NewLiveCertificatePaths(basePath, cert.ID())); loadErr == nil && liveCert != nil {
+		notAfter := liveCert.NotAfter.UTC()
+		daysRemaining := int(math.Floor(notAfter.Sub(now).Hours() / 24))
+		base.NotAfter = &notAfter
+		base.DaysRemaining = &daysRemaining
+		dueAt := RenewalTime(liveCert, cert.RenewDays)
+		renewAt = &dueAt
+	}
+	if metadata, readErr := ReadLiveMetadata(basePath, cert.ID()); readErr == nil {
+		base.Issuer = metadata.Issuer
+		// The time picked within the issuer's renewal window takes the place of renew_days
+		if renewAt != nil && metadata.RenewAt != nil {
+			renewAt = metadata.RenewAt
+		}
+	}
+	if backoff, readErr := ReadBackoffState(basePath, cert.ID()); readErr == nil {
+		base.Failures = backoff.Failures
+	}
+
+	events := []Event{}
+	switch result {
+	case ResultIssued:
+		event := base
+		event.Type = EventIssued
+		events = append(events, event)
+	case ResultRenewed:
+		event := base
+		event.Type = EventRenewed
+		events = append(events, event)
+	default:
+		if err != nil || result == ResultFailed || result == ResultDeferred {
+			event := base
+			event.Type = EventFailed
+			if err != nil {
+				event.Error = err.Error()
+			}
+			events = append(events, event)
+		}
+		// A certificate is only worth warning about once its renewal is overdue, and the notifiers
+		// decide if it is close enough to expiry
+		if renewAt != nil && now.After(*renewAt) {
+			event := base
+			event.Type = EventExpiring
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// queue picks the events the notifier sends, in order
+// Email folds the expiring event of a certificate into its failed event, which warns about the expiry itself
+func (notifier Notifier) queue(events []Event) []Event {
+	failed := map[string]bool{}
+	for _, event := range events {
+		if event.Type == EventFailed && notifier.Wants(event) {
+			failed[event.Certificate] = true
+		}
+	}
+
+	queued := []Event{}
+	for _, event := range events {
+		if !notifier.Wants(event) {
+			continue
+		}
+		if notifier.Type == "smtp" && event.Type == EventExpiring && failed[event.Certificate] {
+			continue
+		}
+		queued = append(queued, event)
+	}
+	return queued
+}
+
+// rateLimitKey identifies the messages that are rate limited together
+// A failing certificate that comes close to expiry is keyed apart so the more urgent warning isn't held back
+func (notifier Notifier) rateLimitKey(event Event) string {
+	if event.Type == EventFailed && notifier.Type == "smtp" && notifier.expiringSoon(event) {
+		return event.Certificate + "/" + EventExpiring
+	}
+	return event.Certificate + "/" + event.Type
+}
+
+// Notify sends the events to every notifier that wants them, waiting for the deliveries to finish
+// Delivery failures are logged rather than failing the certificates
+func (config Config) Notify(ctx context.Context, events []Event) {
+	appConfig := config.Roadrunner.Config
+	deliveries := sync.WaitGroup{}
+	for _, notifier := range config.Roadrunner.Notifiers {
+		queued := notifier.queue(events)
+		if len(queued) == 0 {
+			continue
+		}
+
+		// Each notifier delivers its events in order, alongside the other notifiers
+		deliveries.Add(1)
+		go func(notifier Notifier, queued []Event) {
+			defer deliveries.Done()
+
+			// The scheduler and admin jobs notify at the same time, so the state is read and written by one at a time
+			unlock, err := NotifierLocks.Lock(ctx, notifier.Name)
+			if err != nil {
+				return
+			}
+			defer unlock()
+
+			rateLimit := notifier.rateLimit()
+			var sent NotificationState
+			if rateLimit > 0 {
+				if sent, err = ReadNotificationState(appConfig.WorkingDir, notifier.Name); err != nil {
+					logging.LogStdOutWarn(fmt.Sprintf("[notifier %v] Failed to read the rate limit state, sending anyway: %v", notifier.Name, err))
+				}
+			}
+
+			for _, event := range queued {
+				key := notifier.rateLimitKey(event)
+				if last, ok := sent[key]; ok && rateLimit > 0 && time.Since(last) < rateLimit {
+					logging.LogStdOutInfo(fmt.Sprintf("[notifier %v] Not sending the %v event for %v, rate limited since %v", notifier.Name, event.Type, event.Certificate, last.Format(time.RFC3339)))
+					continue
+				}
+				if err := notifier.send(ctx, appConfig, event); err != nil {
+					logging.LogStdOutWarn(fmt.Sprintf("[notifier %v] Failed to send the %v event for %v: %v", notifier.Name, event.Type, event.Certificate, err))
+					continue
+				}
+				logging.LogStdOutInfo(fmt.Sprintf("[notifier %v] Sent the %v event for %v", notifier.Name, event.Type, event.Certificate))
+				if rateLimit > 0 {
+					sent[key] = time.Now().UTC()
+				}
+			}
+
+			if rateLimit > 0 {
+				if err := WriteNotificationState(appConfig.WorkingDir, notifier.Name, sent.Prune(rateLimit)); err != nil {
+					logging.LogStdOutWarn(fmt.Sprintf("[notifier %v] Failed to write the rate limit state: %v", notifier.Name, err))
+				}
+			}
+		}(notifier, queued)
+	}
+	deliveries.Wait()
+}
+
+// NotificationState records when a notifier last sent each rate limited message
+// It is kept in the working directory so a CLI run from cron is rate limited too
+type NotificationState map[string]time.Time
+
+// notificationStatePath returns the path to the rate limit state file for a named notifier
+func notificationStatePath(basePath string, name string) string {
+	return helpers.AppendSlash(basePath) + ".acme/notifications/" + name + ".yml"
+}
+
+// ReadNotificationState reads the rate limit state for a notifier, returning an empty state if there is none
+func ReadNotificationState(basePath string, name string) (NotificationState, error) {
+	state := NotificationState{}
+	stateBytes, err := ReadFileToBytes(notificationStatePath(basePath, name))
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	if err := yaml.Unmarshal(stateBytes, &state); err != nil {
+		return NotificationState{}, err
+	}
+	return state, nil
+}
+
+// WriteNotificationState writes the rate limit state for a notifier
+func WriteNotificationState(basePath string, name string, state NotificationState) error {
+	stateBytes, err := yaml.Marshal(state)
+	if err != nil {
+		return err
+	}
+	_, err = WriteByteFile(notificationStatePath(basePath, name), stateBytes, 0644, true)
+	return err
+}
+
+// Prune drops the messages sent longer than the rate limit ago, which no longer hold anything back
+func (state NotificationState) Prune(rateLimit time.Duration) NotificationState {
+	for key, last := range state {
+		if time.Since(last) >= rateLimit {
+			delete(state, key)
+		}
+	}
+	return state
+}
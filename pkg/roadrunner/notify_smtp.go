@@ -0,0 +1,254 @@
+package roadrunner
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+)
+
+// validateSMTP checks the settings of an smtp notifier
+func (notifier Notifier) validateSMTP() error {
+	if notifier.Server == "" {
+		return fmt.Errorf("smtp notifier needs a server")
+	}
+	switch notifier.TLS {
+	case "", "starttls", "implicit", "none":
+	default:
+		return fmt.Errorf("unknown tls mode [%v], options are starttls, implicit and none", notifier.TLS)
+	}
+	if (notifier.Username == "") != (notifier.Password == "") {
+		return fmt.Errorf("username and password must be set together")
+	}
+	if _, err := mail.ParseAddress(notifier.From); err != nil {
+		return fmt.Errorf("invalid from address [%v]: %v", notifier.From, err)
+	}
+	for _, to := range notifier.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			return fmt.Errorf("invalid to address [%v]: %v", to, err)
+		}
+	}
+	return nil
+}
+
+// smtpAddress returns the host and host:port of the SMTP server, adding the default port for the TLS mode
+func (notifier Notifier) smtpAddress() (string, string) {
+	if host, _, err := net.SplitHostPort(notifier.Server); err == nil {
+		return host, notifier.Server
+	}
+	port := "587"
+	switch notifier.TLS {
+	case "implicit":
+		port = "465"
+	case "none":
+		port = "25"
+	}
+	return notifier.Server, net.JoinHostPort(notifier.Server, port)
+}
+
+// emailRecipients are the addresses an event is sent to, the configured ones or the certificate contact
+func (notifier Notifier) emailRecipients(event Event) []string {
+	if len(notifier.To) > 0 {
+		return notifier.To
+	}
+	if event.Email != "" {
+		return []string{event.Email}
+	}
+	return nil
+}
+
+// sendSMTP emails the event
+// It reports if a failed delivery is worth retrying, which is anything but a permanent 5xx rejection
+func (notifier Notifier) sendSMTP(ctx context.Context, appConfig AppConfig, event Event) (bool, time.Time, error) {
+	recipients := notifier.emailRecipients(event)
+	if len(recipients) == 0 {
+		return false, time.Time{}, fmt.Errorf("no recipients, set to on the notifier or email on the certificate")
+	}
+	message, err := notifier.emailMessage(event, recipients)
+	if err != nil {
+		return false, time.Time{}, err
+	}
+
+	timeout := notifier.Timeout
+	if timeout == 0 {
+		timeout = DefaultNotifierTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	host, address := notifier.smtpAddress()
+	tlsConfig, err := NewTLSConfig(ConnectionInfo{SkipTLSVerify: appConfig.SkipTLSVerify, CAFile: appConfig.CAFile})
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	tlsConfig.ServerName = host
+
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return true, time.Time{}, err
+	}
+	defer conn.Close()
+	// net/smtp has no context support, the deadline bounds the whole conversation instead
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if notifier.TLS == "implicit" {
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return smtpRetryable(err), time.Time{}, err
+		}
+		conn = tlsConn
+	}
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return smtpRetryable(err), time.Time{}, err
+	}
+	defer client.Close()
+
+	if hostname, err := os.Hostname(); err == nil {
+		if err := client.Hello(hostname); err != nil {
+			return smtpRetryable(err), time.Time{}, err
+		}
+	}
+	if notifier.TLS == "" || notifier.TLS == "starttls" {
+		// Never fall back to plain text, the credentials and the message would go over the wire in the clear
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return false, time.Time{}, fmt.Errorf("%v doesn't support STARTTLS, set tls to none to send without it", address)
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return smtpRetryable(err), time.Time{}, err
+		}
+	}
+	if notifier.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", notifier.Username, notifier.Password, host)); err != nil {
+			return smtpRetryable(err), time.Time{}, fmt.Errorf("authenticating: %w", err)
+		}
+	}
+
+	from, _ := mail.ParseAddress(notifier.From)
+	if err := client.Mail(from.Address); err != nil {
+		return smtpRetryable(err), time.Time{}, err
+	}
+	for _, recipient := range recipients {
+		to, err := mail.ParseAddress(recipient)
+		if err != nil {
+			return false, time.Time{}, fmt.Errorf("invalid recipient [%v]: %v", recipient, err)
+		}
+		if err := client.Rcpt(to.Address); err != nil {
+			return smtpRetryable(err), time.Time{}, err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return smtpRetryable(err), time.Time{}, err
+	}
+	if _, err := writer.Write(message); err != nil {
+		return smtpRetryable(err), time.Time{}, err
+	}
+	if err := writer.Close(); err != nil {
+		return smtpRetryable(err), time.Time{}, err
+	}
+	client.Quit()
+	return false, time.Time{}, nil
+}
+
+// smtpRetryable reports if an SMTP error is worth retrying, which is only a temporary 4xx reply or the
+// network letting us down
+// A 5xx reply, a server certificate that doesn't verify or net/smtp refusing to send the password in the
+// clear won't change by sending it again
+func smtpRetryable(err error) bool {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return protoErr.Code >= 400 && protoErr.Code < 500
+	}
+
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	if errors.As(err, &unknownAuthority) || errors.As(err, &hostname) || errors.As(err, &invalid) {
+		return false
+	}
+
+	// A dropped connection or a timeout may well go through next time
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// emailSubject summarises the event in a few words for the subject line
+func (notifier Notifier) emailSubject(event Event) string {
+	switch event.Type {
+	case EventFailed:
+		if notifier.expiringSoon(event) {
+			if *event.DaysRemaining < 0 {
+				return fmt.Sprintf("%v has expired and renewal keeps failing", event.Certificate)
+			}
+			return fmt.Sprintf("%v expires in %d days and renewal keeps failing", event.Certificate, *event.DaysRemaining)
+		}
+		return fmt.Sprintf("%v failed on %v", event.Certificate, event.Host)
+	case EventExpiring:
+		if *event.DaysRemaining < 0 {
+			return fmt.Sprintf("%v has expired", event.Certificate)
+		}
+		return fmt.Sprintf("%v expires in %d days", event.Certificate, *event.DaysRemaining)
+	case EventIssued:
+		return fmt.Sprintf("%v was issued", event.Certificate)
+	case EventRenewed:
+		return fmt.Sprintf("%v was renewed", event.Certificate)
+	}
+	return fmt.Sprintf("%v: %v", event.Certificate, event.Type)
+}
+
+// emailMessage renders the event as a plain text email
+func (notifier Notifier) emailMessage(event Event, recipients []string) ([]byte, error) {
+	body := &bytes.Buffer{}
+	writer := quotedprintable.NewWriter(body)
+	fmt.Fprintf(writer, "%v\r\n\r\n", event.Summary())
+	for _, detail := range eventDetails(event) {
+		fmt.Fprintf(writer, "%v: %v\r\n", detail[0], detail[1])
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	messageID := make([]byte, 16)
+	if _, err := rand.Read(messageID); err != nil {
+		return nil, err
+	}
+	from, _ := mail.ParseAddress(notifier.From)
+	domain := from.Address[strings.LastIndex(from.Address, "@")+1:]
+
+	headers := [][2]string{
+		{"From", from.String()},
+		{"To", strings.Join(recipients, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", "[roadrunner] "+notifier.emailSubject(event))},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", "<" + hex.EncodeToString(messageID) + "@" + domain + ">"},
+		{"X-Roadrunner-Event", event.Type},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "text/plain; charset=utf-8"},
+		{"Content-Transfer-Encoding", "quoted-printable"},
+	}
+	message := &bytes.Buffer{}
+	for _, header := range headers {
+		fmt.Fprintf(message, "%v: %v\r\n", header[0], header[1])
+	}
+	message.WriteString("\r\n")
+	message.Write(body.Bytes())
+	return message.Bytes(), nil
+}
@@ -0,0 +1,53 @@
+package roadrunner
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"testing"
+)
+
+func TestSMTPRetryable(t *testing.T) {
+	// net/smtp refuses to send PLAIN auth to a remote server without TLS
+	_, _, authErr := smtp.PlainAuth("", "roadrunner", "secret", "mail.example.test").Start(&smtp.ServerInfo{Name: "mail.example.test", Auth: []string{"PLAIN"}})
+	if authErr == nil {
+		t.Fatal("PLAIN auth without TLS was allowed")
+	}
+
+	// A real handshake with a server certificate the roots don't know
+	server := httptest.NewTLSServer(nil)
+	defer server.Close()
+	_, verifyErr := tls.Dial("tcp", server.Listener.Addr().String(), &tls.Config{RootCAs: x509.NewCertPool(), ServerName: "example.com"})
+	if verifyErr == nil {
+		t.Fatal("an unknown server certificate verified")
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"service unavailable", &textproto.Error{Code: 421, Msg: "try again later"}, true},
+		{"mailbox busy", fmt.Errorf("sending: %w", &textproto.Error{Code: 450, Msg: "mailbox busy"}), true},
+		{"authentication failed", &textproto.Error{Code: 535, Msg: "bad credentials"}, false},
+		{"unknown recipient", &textproto.Error{Code: 550, Msg: "no such user"}, false},
+		{"auth over plain text", fmt.Errorf("authenticating: %w", authErr), false},
+		{"unverified certificate", verifyErr, false},
+		{"wrong hostname", x509.HostnameError{Host: "mail.example.test"}, false},
+		{"timeout", &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}, true},
+		{"connection refused", &net.OpError{Op: "dial", Net: "tcp", Err: fmt.Errorf("connection refused")}, true},
+		{"connection dropped", io.EOF, true},
+		{"anything else", fmt.Errorf("unexpected"), false},
+	}
+	for _, tt := range tests {
+		if got := smtpRetryable(tt.err); got != tt.want {
+			t.Errorf("%v: smtpRetryable(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package roadrunner
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCertificateEventsExpiring(t *testing.T) {
+	now := time.Now()
+	hourAgo, tomorrow := now.Add(-time.Hour), now.Add(24*time.Hour)
+
+	tests := []struct {
+		name      string
+		renewDays int
+		renewAt   *time.Time
+		result    CertificateResult
+		err       error
+		want      []string
+	}{
+		{"not due yet", 10, nil, ResultUnchanged, nil, []string{}},
+		{"overdue", 30, nil, ResultUnchanged, nil, []string{EventExpiring}},
+		{"overdue and failing", 30, nil, ResultFailed, fmt.Errorf("no"), []string{EventFailed, EventExpiring}},
+		{"failing before it is due", 10, nil, ResultFailed, fmt.Errorf("no"), []string{EventFailed}},
+		{"renew_at still ahead", 30, &tomorrow, ResultDeferred, nil, []string{EventFailed}},
+		{"renew_at passed", 10, &hourAgo, ResultUnchanged, nil, []string{EventExpiring}},
+		{"renewed", 30, nil, ResultRenewed, nil, []string{EventRenewed}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The live certificate has 20 days of its 90 left
+			_, chainPEM, keyPEM := issueTestCertificate(t, "example.test", now.Add(-70*24*time.Hour), now.Add(20*24*time.Hour))
+			config := Config{}
+			config.Roadrunner.Config.WorkingDir = t.TempDir() + "/"
+			cert := Certificate{Domains: []string{"example.test"}, RenewDays: tt.renewDays}
+			if _, err := StoreLiveCertificate(config.Roadrunner.Config.WorkingDir, cert.ID(), chainPEM, keyPEM); err != nil {
+				t.Fatalf("StoreLiveCertificate: %v", err)
+			}
+			if err := WriteLiveMetadata(config.Roadrunner.Config.WorkingDir, cert.ID(), LiveMetadata{Issuer: "test", RenewAt: tt.renewAt}); err != nil {
+				t.Fatalf("WriteLiveMetadata: %v", err)
+			}
+
+			got := []string{}
+			for _, event := range config.CertificateEvents(cert, tt.result, tt.err) {
+				got = append(got, event.Type)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("events = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNotifierRateLimit(t *testing.T) {
+	off := time.Duration(0)
+	tests := []struct {
+		notifier Notifier
+		want     time.Duration
+	}{
+		{Notifier{Type: "webhook"}, DefaultWebhookRateLimit},
+		{Notifier{}, DefaultWebhookRateLimit},
+		{Notifier{Type: "smtp"}, DefaultEmailRateLimit},
+		{Notifier{Type: "webhook", RateLimit: &off}, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.notifier.rateLimit(); got != tt.want {
+			t.Errorf("%+v rateLimit() = %v, want %v", tt.notifier, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package roadrunner
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// sendWebhook POSTs the event in the notifier format, through the global proxy and TLS settings
+// It reports if a failed delivery is worth retrying, and when if the receiver asked with Retry-After
+func (notifier Notifier) sendWebhook(ctx context.Context, appConfig AppConfig, event Event) (bool, time.Time, error) {
+	body, err := notifier.webhookPayload(event)
+	if err != nil {
+		return false, time.Time{}, err
+	}
+
+	transport, err := NewHTTPTransport(NewConnectionInfo(appConfig, Issuer{}))
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport}
+
+	timeout := notifier.Timeout
+	if timeout == 0 {
+		timeout = DefaultNotifierTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, notifier.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "roadrunner")
+	req.Header.Set("X-Roadrunner-Event", event.Type)
+	for name, value := range notifier.Headers {
+		req.Header.Set(name, value)
+	}
+
+	// Sign the timestamp along with the body so a captured request can't be replayed later
+	if notifier.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-Roadrunner-Timestamp", timestamp)
+		req.Header.Set("X-Roadrunner-Signature", "sha256="+WebhookSignature(notifier.Secret, timestamp, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, time.Time{}, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, time.Time{}, nil
+	}
+
+	// The receiver rejecting the request won't change by sending it again, unless it is rate limiting
+	retryable := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout
+	retryAt, _ := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	return retryable, retryAt, fmt.Errorf("%v responded with HTTP %d", notifier.URL, resp.StatusCode)
+}
+
+// WebhookSignature is the hex encoded HMAC-SHA256 of "<timestamp>.<body>" with the secret
+func WebhookSignature(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookPayload renders the event in the notifier format
+func (notifier Notifier) webhookPayload(event Event) ([]byte, error) {
+	switch notifier.Format {
+	case "slack":
+		// Slack incoming webhooks, and the many chat tools that accept the same payload
+		return json.Marshal(map[string]interface{}{
+			"text": event.Summary(),
+			"attachments": []map[string]interface{}{{
+				"color":  eventColor(event),
+				"fields": eventFields(event, "title", "value", map[string]interface{}{"short": true}),
+			}},
+		})
+	case "teams":
+		// Microsoft Teams incoming webhooks take a MessageCard
+		return json.Marshal(map[string]interface{}{
+			"@type":      "MessageCard",
+			"@context":   "https://schema.org/extensions",
+			"summary":    event.Summary(),
+			"themeColor": eventColor(event)[1:],
+			"title":      "Roadrunner: certificate " + event.Type,
+			"text":       event.Summary(),
+			"sections": []map[string]interface{}{{
+				"facts": eventFields(event, "name", "value", nil),
+			}},
+		})
+	default:
+		return json.Marshal(event)
+	}
+}
+
+// eventColor is the colour chat messages use for the event
+func eventColor(event Event) string {
+	switch event.Type {
+	case EventFailed:
+		return "#d32f2f"
+	case EventExpiring:
+		return "#f9a825"
+	}
+	return "#2e7d32"
+}
+
+// eventDetails lists the details of the event as name/value pairs
+func eventDetails(event Event) [][2]string {
+	values := [][2]string{{"Certificate", event.Certificate}, {"Host", event.Host}}
+	if event.Issuer != "" {
+		values = append(values, [2]string{"Issuer", event.Issuer})
+	}
+	if event.NotAfter != nil {
+		values = append(values, [2]string{"Not After", event.NotAfter.UTC().Format(time.RFC3339)})
+	}
+	if event.Failures > 0 {
+		values = append(values, [2]string{"Failures", strconv.Itoa(event.Failures)})
+	}
+	if event.Error != "" {
+		values = append(values, [2]string{"Error", event.Error})
+	}
+	return values
+}
+
+// eventFields lists the details of the event with the keys a chat tool expects
+func eventFields(event Event, nameKey string, valueKey string, extra map[string]interface{}) []map[string]interface{} {
+	fields := []map[string]interface{}{}
+	for _, value := range eventDetails(event) {
+		field := map[string]interface{}{nameKey: value[0], valueKey: value[1]}
+		for key, v := range extra {
+			field[key] = v
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
@@ -0,0 +1,321 @@
+package roadrunner
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver is a stand-in webhook endpoint answering each request with the next of its responses,
+// then 200 OK, and recording what it received
+type webhookReceiver struct {
+	server *httptest.Server
+
+	mu        sync.Mutex
+	responses []webhookResponse
+	requests  []receivedWebhook
+}
+
+// webhookResponse is the status and Retry-After the receiver answers a request with
+type webhookResponse struct {
+	status     int
+	retryAfter string
+}
+
+// receivedWebhook is a request the receiver got
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+	at     time.Time
+}
+
+func newWebhookReceiver(t *testing.T, responses ...webhookResponse) *webhookReceiver {
+	t.Helper()
+	receiver := &webhookReceiver{responses: responses}
+	receiver.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		receiver.mu.Lock()
+		receiver.requests = append(receiver.requests, receivedWebhook{header: r.Header.Clone(), body: body, at: time.Now()})
+		response := webhookResponse{status: http.StatusOK}
+		if len(receiver.responses) > 0 {
+			response, receiver.responses = receiver.responses[0], receiver.responses[1:]
+		}
+		receiver.mu.Unlock()
+
+		if response.retryAfter != "" {
+			w.Header().Set("Retry-After", response.retryAfter)
+		}
+		w.WriteHeader(response.status)
+	}))
+	t.Cleanup(receiver.server.Close)
+	return receiver
+}
+
+// received returns the requests the receiver got so far
+func (receiver *webhookReceiver) received() []receivedWebhook {
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	return append([]receivedWebhook{}, receiver.requests...)
+}
+
+// testEvent is an event of the type for a failing certificate with 9 days left
+func testEvent(eventType string) Event {
+	notAfter := time.Date(2026, 11, 2, 12, 0, 0, 0, time.UTC)
+	daysRemaining := 9
+	return Event{
+		Type:          eventType,
+		Certificate:   "example.test",
+		Domains:       []string{"example.test", "www.example.test"},
+		Issuer:        "test-ca",
+		NotAfter:      &notAfter,
+		DaysRemaining: &daysRemaining,
+		Error:         "challenge failed",
+		Failures:      2,
+		Host:          "web01",
+		Time:          time.Date(2026, 10, 24, 8, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestWebhookPayloads(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	event := testEvent(EventFailed)
+
+	tests := []struct {
+		format string
+		check  func(t *testing.T, payload map[string]interface{})
+	}{
+		{"json", func(t *testing.T, payload map[string]interface{}) {
+			want := map[string]interface{}{
+				"event": "failed", "certificate": "example.test", "issuer": "test-ca", "not_after": "2026-11-02T12:00:00Z",
+				"days_remaining": 9.0, "error": "challenge failed", "failures": 2.0, "host": "web01", "time": "2026-10-24T08:00:00Z",
+			}
+			for key, value := range want {
+				if payload[key] != value {
+					t.Errorf("%v = %v, want %v", key, payload[key], value)
+				}
+			}
+			if _, ok := payload["Email"]; ok {
+				t.Error("the certificate email is in the payload")
+			}
+		}},
+		{"slack", func(t *testing.T, payload map[string]interface{}) {
+			if payload["text"] != event.Summary() {
+				t.Errorf("text = %v, want %v", payload["text"], event.Summary())
+			}
+			attachment := payload["attachments"].([]interface{})[0].(map[string]interface{})
+			if attachment["color"] != "#d32f2f" {
+				t.Errorf("color = %v, want the failed red", attachment["color"])
+			}
+			field := attachment["fields"].([]interface{})[0].(map[string]interface{})
+			if field["title"] != "Certificate" || field["value"] != "example.test" || field["short"] != true {
+				t.Errorf("first field = %v, want the short certificate name", field)
+			}
+		}},
+		{"teams", func(t *testing.T, payload map[string]interface{}) {
+			if payload["@type"] != "MessageCard" || payload["themeColor"] != "d32f2f" || payload["title"] != "Roadrunner: certificate failed" {
+				t.Errorf("card = %v, want a red failed MessageCard", payload)
+			}
+			facts := payload["sections"].([]interface{})[0].(map[string]interface{})["facts"].([]interface{})
+			last := facts[len(facts)-1].(map[string]interface{})
+			if last["name"] != "Error" || last["value"] != "challenge failed" {
+				t.Errorf("last fact = %v, want the error", last)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			notifier := Notifier{Name: "test", URL: receiver.server.URL, Format: tt.format, Headers: map[string]string{"Authorization": "Bearer token"}}
+			if _, _, err := notifier.sendWebhook(context.Background(), AppConfig{}, event); err != nil {
+				t.Fatalf("sendWebhook: %v", err)
+			}
+			requests := receiver.received()
+			request := requests[len(requests)-1]
+			for name, want := range map[string]string{"Content-Type": "application/json", "X-Roadrunner-Event": "failed", "Authorization": "Bearer token"} {
+				if got := request.header.Get(name); got != want {
+					t.Errorf("%v header = %q, want %q", name, got, want)
+				}
+			}
+			if request.header.Get("X-Roadrunner-Signature") != "" {
+				t.Error("signed without a secret")
+			}
+
+			payload := map[string]interface{}{}
+			if err := json.Unmarshal(request.body, &payload); err != nil {
+				t.Fatalf("decoding the payload: %v", err)
+			}
+			tt.check(t, payload)
+		})
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	notifier := Notifier{Name: "test", URL: receiver.server.URL, Secret: "s3cret"}
+	before := time.Now().Unix()
+	if _, _, err := notifier.sendWebhook(context.Background(), AppConfig{}, testEvent(EventRenewed)); err != nil {
+		t.Fatalf("sendWebhook: %v", err)
+	}
+	request := receiver.received()[0]
+
+	timestamp := request.header.Get("X-Roadrunner-Timestamp")
+	if unix, err := strconv.ParseInt(timestamp, 10, 64); err != nil || unix < before || unix > time.Now().Unix() {
+		t.Errorf("X-Roadrunner-Timestamp = %q, want the time of the request", timestamp)
+	}
+
+	// Recompute it the way a receiver would, over "<timestamp>.<body>"
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(timestamp + "." + string(request.body)))
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if got := request.header.Get("X-Roadrunner-Signature"); !hmac.Equal([]byte(got), []byte(want)) {
+		t.Errorf("X-Roadrunner-Signature = %q, want %q", got, want)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	retries := 2
+	tests := []struct {
+		name      string
+		responses []webhookResponse
+		wantSent  bool
+		wantTries int
+		// wantDelay is the least time between the first and the last attempt
+		wantDelay time.Duration
+	}{
+		{"rate limited", []webhookResponse{{http.StatusTooManyRequests, "1"}}, true, 2, time.Second},
+		{"unavailable", []webhookResponse{{http.StatusServiceUnavailable, "1"}, {http.StatusBadGateway, "1"}}, true, 3, 2 * time.Second},
+		{"keeps failing", []webhookResponse{{http.StatusInternalServerError, "1"}, {http.StatusInternalServerError, "1"}, {http.StatusInternalServerError, "1"}}, false, 3, 2 * time.Second},
+		{"asked to wait a day", []webhookResponse{{http.StatusTooManyRequests, "86400"}}, false, 1, 0},
+		{"asked to wait for a far off date", []webhookResponse{{http.StatusServiceUnavailable, "Fri, 01 Jan 2100 00:00:00 GMT"}}, false, 1, 0},
+		{"rejected", []webhookResponse{{http.StatusBadRequest, ""}}, false, 1, 0},
+		{"unauthorized", []webhookResponse{{http.StatusUnauthorized, "1"}}, false, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiver := newWebhookReceiver(t, tt.responses...)
+			notifier := Notifier{Name: "test", URL: receiver.server.URL, Retries: &retries}
+			err := notifier.send(context.Background(), AppConfig{}, testEvent(EventFailed))
+			if sent := err == nil; sent != tt.wantSent {
+				t.Errorf("send() = %v, want sent %v", err, tt.wantSent)
+			}
+
+			requests := receiver.received()
+			if len(requests) != tt.wantTries {
+				t.Fatalf("%d attempts, want %d", len(requests), tt.wantTries)
+			}
+			// Retry-After is followed rather than the longer exponential backoff
+			delay := requests[len(requests)-1].at.Sub(requests[0].at)
+			if delay < tt.wantDelay-100*time.Millisecond || delay >= tt.wantDelay+NotifierRetryDelay {
+				t.Errorf("attempts spread over %v, want about %v", delay, tt.wantDelay)
+			}
+		})
+	}
+}
+
+func TestNotifyWebhookEvents(t *testing.T) {
+	off := time.Duration(0)
+	farOff := testEvent(EventExpiring)
+	daysRemaining := 30
+	farOff.Certificate, farOff.DaysRemaining = "far-off.test", &daysRemaining
+	events := []Event{testEvent(EventIssued), testEvent(EventFailed), testEvent(EventExpiring), farOff}
+
+	tests := []struct {
+		name     string
+		notifier Notifier
+		want     []string
+	}{
+		{"every event", Notifier{}, []string{"issued example.test", "failed example.test", "expiring example.test"}},
+		{"filtered", Notifier{Events: []string{EventFailed, EventExpiring}}, []string{"failed example.test", "expiring example.test"}},
+		{"wider warning", Notifier{Events: []string{EventExpiring}, ExpiryWarningDays: 45}, []string{"expiring example.test", "expiring far-off.test"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiver := newWebhookReceiver(t)
+			tt.notifier.Name, tt.notifier.URL, tt.notifier.RateLimit = "test", receiver.server.URL, &off
+			config := Config{}
+			config.Roadrunner.Config.WorkingDir = t.TempDir() + "/"
+			config.Roadrunner.Notifiers = []Notifier{tt.notifier}
+			config.Notify(context.Background(), events)
+
+			got := []string{}
+			for _, request := range receiver.received() {
+				event := Event{}
+				if err := json.Unmarshal(request.body, &event); err != nil {
+					t.Fatalf("decoding the payload: %v", err)
+				}
+				got = append(got, event.Type+" "+event.Certificate)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("sent %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("sent %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestNotifyWebhookRateLimit(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	config := Config{}
+	config.Roadrunner.Config.WorkingDir = t.TempDir() + "/"
+	if err := os.MkdirAll(config.Roadrunner.Config.WorkingDir+".acme/notifications", 0700); err != nil {
+		t.Fatal(err)
+	}
+	config.Roadrunner.Notifiers = []Notifier{{Name: "test", URL: receiver.server.URL}}
+
+	// A failing certificate checked again within the default rate limit is only reported once
+	config.Notify(context.Background(), []Event{testEvent(EventFailed)})
+	config.Notify(context.Background(), []Event{testEvent(EventFailed), testEvent(EventRenewed)})
+	events := []string{}
+	for _, request := range receiver.received() {
+		events = append(events, request.header.Get("X-Roadrunner-Event"))
+	}
+	if len(events) != 2 || events[0] != EventFailed || events[1] != EventRenewed {
+		t.Errorf("sent %v, want [failed renewed]", events)
+	}
+}
+
+func TestNotifyConcurrentRateLimit(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	config := Config{}
+	config.Roadrunner.Config.WorkingDir = t.TempDir() + "/"
+	if err := os.MkdirAll(config.Roadrunner.Config.WorkingDir+".acme/notifications", 0700); err != nil {
+		t.Fatal(err)
+	}
+	config.Roadrunner.Notifiers = []Notifier{{Name: "test", URL: receiver.server.URL}}
+
+	// The scheduler and admin jobs reporting the same failure at once send it once between them
+	var notifying sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		notifying.Add(1)
+		go func() {
+			defer notifying.Done()
+			config.Notify(context.Background(), []Event{testEvent(EventFailed)})
+		}()
+	}
+	notifying.Wait()
+
+	if n := len(receiver.received()); n != 1 {
+		t.Errorf("sent %d webhooks, want 1", n)
+	}
+	state, err := ReadNotificationState(config.Roadrunner.Config.WorkingDir, "test")
+	if err != nil {
+		t.Fatalf("ReadNotificationState: %v", err)
+	}
+	if _, ok := state["example.test/"+EventFailed]; !ok {
+		t.Errorf("rate limit state = %v, want the failed event recorded", state)
+	}
+}
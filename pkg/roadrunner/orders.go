@@ -0,0 +1,189 @@
+package roadrunner
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/mholt/acmez"
+	"github.com/mholt/acmez/acme"
+	"golang.org/x/net/idna"
+)
+
+// ProblemTypeAlreadyReplaced is returned when the certificate named in replaces was already replaced
+const ProblemTypeAlreadyReplaced = acme.ProblemTypeNamespace + "alreadyReplaced"
+
+// preferredChallengeTypes is the order challenge types are attempted in when a solver is available
+var preferredChallengeTypes = []string{
+	acme.ChallengeTypeHTTP01,
+	acme.ChallengeTypeTLSALPN01,
+	acme.ChallengeTypeDNS01,
+}
+
+// NewOrder assembles an ACME order for the domains, optionally requesting a validity period
+func NewOrder(domains []string, notBefore *time.Time, notAfter *time.Time) acme.Order {
+	order := acme.Order{
+		NotBefore: notBefore,
+		NotAfter:  notAfter,
+	}
+	for _, domain := range domains {
+		if ip := net.ParseIP(domain); ip != nil {
+			order.Identifiers = append(order.Identifiers, acme.Identifier{Type: "ip", Value: ip.String()})
+		} else {
+			order.Identifiers = append(order.Identifiers, acme.Identifier{Type: "dns", Value: domain})
+		}
+	}
+	return order
+}
+
+// ObtainCertificateWithOrder runs the full ACME order flow for a prepared order
+// Unlike acmez.Client.ObtainCertificate this allows setting order fields such as notBefore/notAfter,
+// and the replaces field when the order renews a certificate from the same issuer
+func ObtainCertificateWithOrder(ctx context.Context, client acmez.Client, account acme.Account, order acme.Order, replacement *OrderReplacement, certPrivateKey crypto.Signer) ([]acme.Certificate, error) {
+	if account.Status != acme.StatusValid {
+		return nil, fmt.Errorf("account status is not valid: %s", account.Status)
+	}
+
+	// Build the CSR from the order identifiers
+	csr, err := newCSR(order.Identifiers, certPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create the order for the new certificate
+	order, err = newOrder(ctx, client, account, order, replacement)
+	if err != nil {
+		return nil, fmt.Errorf("creating new order: %w", err)
+	}
+
+	// Solve one challenge for each authorization on the order
+	for _, authzURL := range order.Authorizations {
+		if err := solveAuthorization(ctx, client, account, authzURL); err != nil {
+			return nil, fmt.Errorf("solving authorization: %w (order=%s)", err, order.Location)
+		}
+	}
+
+	// Finalize the order, which requests the CA to issue us a certificate
+	order, err = client.Client.FinalizeOrder(ctx, account, order, csr.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("finalizing order %s: %w", order.Location, err)
+	}
+
+	// Finally, download the certificate chains
+	certChains, err := client.Client.GetCertificateChain(ctx, account, order.Certificate)
+	if err != nil {
+		return nil, fmt.Errorf("downloading certificate chain from %s: %w (order=%s)", order.Certificate, err, order.Location)
+	}
+
+	return certChains, nil
+}
+
+// newOrder creates the order, naming the certificate it replaces when there is one
+// A CA that rejects the replaces field gets a plain order instead
+func newOrder(ctx context.Context, client acmez.Client, account acme.Account, order acme.Order, replacement *OrderReplacement) (acme.Order, error) {
+	if replacement == nil {
+		return client.Client.NewOrder(ctx, account, order)
+	}
+
+	created, err := NewReplacingOrder(ctx, client, account, replacement.Directory, order, replacement.CertID)
+	var problem acme.Problem
+	if errors.As(err, &problem) && (problem.Type == ProblemTypeAlreadyReplaced || problem.Type == acme.ProblemTypeMalformed) {
+		return client.Client.NewOrder(ctx, account, order)
+	}
+	return created, err
+}
+
+// solveAuthorization completes a single authorization using the first challenge type we have a solver for
+func solveAuthorization(ctx context.Context, client acmez.Client, account acme.Account, authzURL string) error {
+	authz, err := client.Client.GetAuthorization(ctx, account, authzURL)
+	if err != nil {
+		return fmt.Errorf("getting authorization %s: %w", authzURL, err)
+	}
+
+	// Authorizations can already be valid, eg from a previous order for the same identifiers
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+	if authz.Status != acme.StatusPending {
+		return fmt.Errorf("authorization for %s has status %s", authz.IdentifierValue(), authz.Status)
+	}
+
+	challenge, solver, err := selectChallenge(client, authz)
+	if err != nil {
+		return err
+	}
+
+	if err := solver.Present(ctx, challenge); err != nil {
+		return fmt.Errorf("presenting %s challenge for %s: %w", challenge.Type, authz.IdentifierValue(), err)
+	}
+	// Always clean up, even when the run is being cancelled
+	defer solver.CleanUp(context.Background(), challenge)
+
+	if waiter, ok := solver.(acmez.Waiter); ok {
+		if err := waiter.Wait(ctx, challenge); err != nil {
+			return fmt.Errorf("waiting for %s solver for %s: %w", challenge.Type, authz.IdentifierValue(), err)
+		}
+	}
+
+	if _, err := client.Client.InitiateChallenge(ctx, account, challenge); err != nil {
+		RoadrunnerMetrics.RecordChallengeFailure(challenge.Type)
+		return fmt.Errorf("initiating %s challenge for %s: %w", challenge.Type, authz.IdentifierValue(), err)
+	}
+
+	authz, err = client.Client.PollAuthorization(ctx, account, authz)
+	if err != nil {
+		RoadrunnerMetrics.RecordChallengeFailure(challenge.Type)
+		return fmt.Errorf("%s challenge for %s: %w", challenge.Type, authz.IdentifierValue(), err)
+	}
+
+	return nil
+}
+
+// selectChallenge picks the most preferred challenge offered that we have a solver for
+func selectChallenge(client acmez.Client, authz acme.Authorization) (acme.Challenge, acmez.Solver, error) {
+	for _, challengeType := range preferredChallengeTypes {
+		solver, ok := client.ChallengeSolvers[challengeType]
+		if !ok || solver == nil {
+			continue
+		}
+		for _, challenge := range authz.Challenges {
+			if challenge.Type == challengeType {
+				return challenge, solver, nil
+			}
+		}
+	}
+
+	return acme.Challenge{}, nil, fmt.Errorf("no solver available for the challenges offered for %s", authz.IdentifierValue())
+}
+
+// newCSR creates a parsed certificate request for the identifiers
+func newCSR(identifiers []acme.Identifier, certPrivateKey crypto.Signer) (*x509.CertificateRequest, error) {
+	if len(identifiers) == 0 {
+		return nil, fmt.Errorf("no identifiers provided")
+	}
+
+	csrTemplate := new(x509.CertificateRequest)
+	for _, id := range identifiers {
+		switch id.Type {
+		case "ip":
+			csrTemplate.IPAddresses = append(csrTemplate.IPAddresses, net.ParseIP(id.Value))
+		default:
+			normalizedName, err := idna.ToASCII(id.Value)
+			if err != nil {
+				return nil, fmt.Errorf("converting identifier '%s' to ASCII: %v", id.Value, err)
+			}
+			csrTemplate.DNSNames = append(csrTemplate.DNSNames, normalizedName)
+		}
+	}
+
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, csrTemplate, certPrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("generating CSR: %v", err)
+	}
+	return x509.ParseCertificateRequest(csrDER)
+}
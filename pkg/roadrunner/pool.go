@@ -0,0 +1,68 @@
+package roadrunner
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// certificateGroups splits the certificates into groups that can be processed in parallel, keeping their order
+// Certificates for the same set of domains, like key_types variants, are grouped so they run one after the other
+// and the later orders reuse the authorizations validated by the first
+func certificateGroups(certs []Certificate) [][]int {
+	groups := [][]int{}
+	groupIndex := map[string]int{}
+	for i, cert := range certs {
+		key := strings.Join(configuredIdentifiers(cert.Domains), ",")
+		if idx, ok := groupIndex[key]; ok {
+			groups[idx] = append(groups[idx], i)
+			continue
+		}
+		groupIndex[key] = len(groups)
+		groups = append(groups, []int{i})
+	}
+	return groups
+}
+
+// WorkerCount returns how many certificates are processed at the same time
+func (appConfig AppConfig) WorkerCount() int {
+	if appConfig.Workers > 0 {
+		return appConfig.Workers
+	}
+	return DefaultWorkers
+}
+
+// IssuerLimiter bounds how many orders run against each Issuer at the same time
+type IssuerLimiter struct {
+	mu    sync.Mutex
+	slots map[string]chan struct{}
+}
+
+// NewIssuerLimiter creates an IssuerLimiter
+func NewIssuerLimiter() *IssuerLimiter {
+	return &IssuerLimiter{slots: map[string]chan struct{}{}}
+}
+
+// Acquire waits for a free slot on the Issuer and returns the function that releases it
+// Issuers without a max_concurrency are only bounded by the worker count
+func (l *IssuerLimiter) Acquire(ctx context.Context, issuer Issuer) (func(), error) {
+	if issuer.MaxConcurrency <= 0 {
+		return func() {}, nil
+	}
+
+	l.mu.Lock()
+	slots, ok := l.slots[issuer.Name]
+	// A reload may have changed the limit, orders holding the old slots drain on their own
+	if !ok || cap(slots) != issuer.MaxConcurrency {
+		slots = make(chan struct{}, issuer.MaxConcurrency)
+		l.slots[issuer.Name] = slots
+	}
+	l.mu.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,51 @@
+package roadrunner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/kenmoini/roadrunner/internal/logging"
+)
+
+// NewShutdownContext returns a context that is cancelled when the application receives SIGINT or SIGTERM
+func NewShutdownContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+
+	// Set up a channel to listen to for interrupt signals
+	var runChan = make(chan os.Signal, 1)
+	signal.Notify(runChan, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		defer signal.Stop(runChan)
+		select {
+		case interrupt := <-runChan:
+			// If we get one of the pre-prescribed syscalls, gracefully terminate the app
+			// while alerting the user
+			logging.LogStdOutInfo(fmt.Sprintf("Roadrunner is shutting down due to %+v", interrupt))
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
+
+// HandleReloadSignals requests a configuration reload every time the daemon receives SIGHUP
+func (d *Daemon) HandleReloadSignals(ctx context.Context) {
+	var hupChan = make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	defer signal.Stop(hupChan)
+
+	for {
+		select {
+		case <-hupChan:
+			logging.LogStdOutInfo("Received SIGHUP, reloading configuration...")
+			d.RequestReload()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
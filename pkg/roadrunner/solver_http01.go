@@ -0,0 +1,124 @@
+package roadrunner
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kenmoini/roadrunner/internal/logging"
+	"github.com/mholt/acmez/acme"
+)
+
+// HTTP01Solver is an acmez.Solver that serves the http-01 challenge tokens from an embedded HTTP server
+type HTTP01Solver struct {
+	mu sync.RWMutex
+	// keyAuthorizations maps the challenge resource path to the key authorization
+	keyAuthorizations map[string]string
+
+	// startMu guards listener, which is only kept once the challenge server is serving on it
+	startMu  sync.Mutex
+	listener net.Listener
+}
+
+// NewHTTP01Solver creates an HTTP01Solver, the server is started on first use
+func NewHTTP01Solver() *HTTP01Solver {
+	return &HTTP01Solver{keyAuthorizations: map[string]string{}}
+}
+
+// Present makes the key authorization available on the challenge resource path
+func (s *HTTP01Solver) Present(ctx context.Context, chal acme.Challenge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keyAuthorizations[chal.HTTP01ResourcePath()] = chal.KeyAuthorization
+	return nil
+}
+
+// CleanUp removes the key authorization for the challenge
+func (s *HTTP01Solver) CleanUp(ctx context.Context, chal acme.Challenge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keyAuthorizations, chal.HTTP01ResourcePath())
+	return nil
+}
+
+// ServeHTTP answers the ACME server's http-01 challenge requests
+func (s *HTTP01Solver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, "/.well-known/acme-challenge/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.mu.RLock()
+	keyAuthorization, ok := s.keyAuthorizations[r.URL.Path]
+	s.mu.RUnlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	logging.LogNetworkRequestStdOut("Served http-01 challenge "+r.URL.Path, r)
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(keyAuthorization))
+}
+
+// Start starts the challenge server if it isn't already serving, on the socket activated listener
+// named "http-01" if there is one, otherwise by binding the configured address
+// A failed bind isn't remembered, so the next challenge tries again
+func (s *HTTP01Solver) Start(appConfig AppConfig) error {
+	s.startMu.Lock()
+	defer s.startMu.Unlock()
+	if s.listener != nil {
+		return nil
+	}
+
+	listener, ok := activatedHTTP01Listener()
+	if ok {
+		logging.LogStdOutInfo("Serving http-01 challenges on the socket activated listener")
+	} else {
+		address := appConfig.HTTP01Listen
+		if address == "" {
+			address = DefaultHTTP01Listen
+		}
+		var err error
+		if listener, err = net.Listen("tcp", address); err != nil {
+			return fmt.Errorf("binding the http-01 challenge server to [%v]: %v", address, err)
+		}
+		logging.LogStdOutInfo(fmt.Sprintf("Serving http-01 challenges on [%v]", address))
+	}
+	s.listener = listener
+
+	server := &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logging.LogStdOutWarn(fmt.Sprintf("http-01 challenge server stopped: %v", err))
+		}
+		// Let the next challenge start it again
+		s.startMu.Lock()
+		if s.listener == listener {
+			s.listener = nil
+		}
+		s.startMu.Unlock()
+	}()
+	return nil
+}
+
+// activatedHTTP01Listener returns the socket activated listener for the challenge server, which is
+// the one named "http-01" or the only listener passed if there is just one
+func activatedHTTP01Listener() (net.Listener, bool) {
+	if listener, ok := ActivatedListeners[HTTP01ListenerName]; ok {
+		return listener, true
+	}
+	if len(ActivatedListeners) == 1 {
+		for _, listener := range ActivatedListeners {
+			return listener, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,127 @@
+package roadrunner
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kenmoini/roadrunner/internal/systemd"
+)
+
+// TestActivatedHTTP01ListenerHelper runs in the child process started by activatedHTTP01Addr
+// and prints the address of the listener picked for the challenge server
+func TestActivatedHTTP01ListenerHelper(t *testing.T) {
+	if os.Getenv("ROADRUNNER_HTTP01_HELPER") != "1" {
+		t.Skip("only runs as a child process")
+	}
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+
+	var err error
+	if ActivatedListeners, err = systemd.Listeners(); err != nil {
+		t.Fatalf("Listeners: %v", err)
+	}
+	if listener, ok := activatedHTTP01Listener(); ok {
+		os.Stdout.WriteString("HTTP01 " + listener.Addr().String() + "\n")
+	}
+}
+
+// activatedHTTP01Addr passes the listeners to a child test process with LISTEN_FDS and LISTEN_FDNAMES
+// set, and returns the address of the listener it picked for the challenge server, if any
+func activatedHTTP01Addr(t *testing.T, names string, listeners ...*net.TCPListener) string {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestActivatedHTTP01ListenerHelper$", "-test.v")
+	cmd.Env = append(os.Environ(), "ROADRUNNER_HTTP01_HELPER=1", "LISTEN_FDS="+strconv.Itoa(len(listeners)), "LISTEN_FDNAMES="+names)
+	for _, listener := range listeners {
+		file, err := listener.File()
+		if err != nil {
+			t.Fatalf("getting the listener file: %v", err)
+		}
+		defer file.Close()
+		cmd.ExtraFiles = append(cmd.ExtraFiles, file)
+	}
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("child process failed: %v\n%s", err, output)
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		if strings.HasPrefix(line, "HTTP01 ") {
+			return strings.TrimPrefix(line, "HTTP01 ")
+		}
+	}
+	return ""
+}
+
+func TestActivatedHTTP01Listener(t *testing.T) {
+	listen := func() *net.TCPListener {
+		listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatalf("listening: %v", err)
+		}
+		t.Cleanup(func() { listener.Close() })
+		return listener
+	}
+	metrics, http01, other := listen(), listen(), listen()
+
+	tests := []struct {
+		name      string
+		names     string
+		listeners []*net.TCPListener
+		want      string
+	}{
+		{"named among others", "metrics:" + HTTP01ListenerName, []*net.TCPListener{metrics, http01}, http01.Addr().String()},
+		{"named first", HTTP01ListenerName + ":metrics", []*net.TCPListener{http01, metrics}, http01.Addr().String()},
+		{"single unnamed", "", []*net.TCPListener{other}, other.Addr().String()},
+		{"single with another name", "metrics", []*net.TCPListener{other}, other.Addr().String()},
+		{"several without http-01", "metrics:api", []*net.TCPListener{metrics, other}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := activatedHTTP01Addr(t, tt.names, tt.listeners...); got != tt.want {
+				t.Errorf("picked %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTP01SolverStartRetriesBind(t *testing.T) {
+	// Another process is holding the challenge port for now
+	blocker, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	address := blocker.Addr().String()
+	appConfig := AppConfig{HTTP01Listen: address}
+
+	solver := NewHTTP01Solver()
+	if err := solver.Start(appConfig); err == nil {
+		t.Fatal("Start succeeded on a port that is in use")
+	}
+
+	// Once the port is free the next challenge binds it
+	blocker.Close()
+	if err := solver.Start(appConfig); err != nil {
+		t.Fatalf("Start after the port was freed: %v", err)
+	}
+	defer func() {
+		solver.startMu.Lock()
+		solver.listener.Close()
+		solver.startMu.Unlock()
+	}()
+	if err := solver.Start(appConfig); err != nil {
+		t.Errorf("Start while serving: %v", err)
+	}
+
+	resp, err := http.Get("http://" + address + "/.well-known/acme-challenge/unknown")
+	if err != nil {
+		t.Fatalf("GET from the challenge server: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown token answered %d, want 404", resp.StatusCode)
+	}
+}
@@ -0,0 +1,224 @@
+package roadrunner
+
+import (
+	"bytes"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/kenmoini/roadrunner/internal/helpers"
+	"gopkg.in/yaml.v2"
+)
+
+// LiveCertificatePaths are the files kept in the live store for a certificate
+type LiveCertificatePaths struct {
+	// Cert is the leaf certificate
+	Cert string
+	// Chain is the intermediate chain without the leaf
+	Chain string
+	// FullChain is the leaf followed by the intermediate chain
+	FullChain string
+	// PrivateKey is the certificate private key
+	PrivateKey string
+}
+
+// LiveMetadata records facts about the certificate currently in the live store
+type LiveMetadata struct {
+	// Issuer is the name of the Issuer that produced the live certificate
+	Issuer string `yaml:"issuer"`
+	// Endpoint is the ACME directory of the Issuer that produced the live certificate
+	Endpoint string `yaml:"endpoint"`
+	// ChainURL is the URL of the selected certificate chain
+	ChainURL string `yaml:"chain_url,omitempty"`
+	// IssuedAt is when the certificate was stored
+	IssuedAt time.Time `yaml:"issued_at"`
+	// RevokedAt is when the live certificate was revoked, if it has been
+	RevokedAt *time.Time `yaml:"revoked_at,omitempty"`
+	// RenewalWindow is the last ACME Renewal Information window suggested by the issuer
+	RenewalWindow *RenewalWindow `yaml:"renewal_window,omitempty"`
+	// RenewAt is the renewal time selected within the RenewalWindow
+	RenewAt *time.Time `yaml:"renew_at,omitempty"`
+	// RenewalInfoRetryAfter is when the renewal information should next be polled
+	RenewalInfoRetryAfter *time.Time `yaml:"renewal_info_retry_after,omitempty"`
+	// PendingDeployHooks is set when the deploy hooks failed, so they are run again on the next check
+	PendingDeployHooks bool `yaml:"pending_deploy_hooks,omitempty"`
+}
+
+// NewLiveMetadata assembles the live metadata for a freshly issued certificate
+func NewLiveMetadata(issued IssuedCertificate) LiveMetadata {
+	return LiveMetadata{
+		Issuer:   issued.Issuer,
+		Endpoint: issued.Endpoint,
+		ChainURL: issued.Chain.URL,
+		IssuedAt: time.Now().UTC(),
+	}
+}
+
+// liveMetadataPath returns the path to the metadata file for a named certificate
+func liveMetadataPath(basePath string, name string) string {
+	return helpers.AppendSlash(basePath) + ".acme/live/" + name + "/metadata.yml"
+}
+
+// WriteLiveMetadata writes the metadata file next to the live certificate
+func WriteLiveMetadata(basePath string, name string, metadata LiveMetadata) error {
+	metadataBytes, err := yaml.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	_, err = WriteByteFile(liveMetadataPath(basePath, name), metadataBytes, 0644, true)
+	return err
+}
+
+// ReadLiveMetadata reads the metadata file for the live certificate
+func ReadLiveMetadata(basePath string, name string) (LiveMetadata, error) {
+	metadata := LiveMetadata{}
+	metadataBytes, err := ReadFileToBytes(liveMetadataPath(basePath, name))
+	if err != nil {
+		return metadata, err
+	}
+	err = yaml.Unmarshal(metadataBytes, &metadata)
+	return metadata, err
+}
+
+// NewLiveCertificatePaths returns the live store paths for a named certificate
+func NewLiveCertificatePaths(basePath string, name string) LiveCertificatePaths {
+	liveDir := helpers.AppendSlash(basePath) + ".acme/live/" + name + "/"
+	return LiveCertificatePaths{
+		Cert:       liveDir + "cert.pem",
+		Chain:      liveDir + "chain.pem",
+		FullChain:  liveDir + "fullchain.pem",
+		PrivateKey: liveDir + "privkey.pem",
+	}
+}
+
+// StoreLiveCertificate writes the selected chain and private key to the live store
+// and keeps a timestamped copy in the archive
+func StoreLiveCertificate(basePath string, name string, chainPEM []byte, keyPEM []byte) (LiveCertificatePaths, error) {
+	livePaths := NewLiveCertificatePaths(basePath, name)
+	archiveDir := helpers.AppendSlash(basePath) + ".acme/archive/" + name + "/" + time.Now().UTC().Format("20060102150405") + "/"
+
+	leafPEM, intermediatesPEM, err := splitChainPEM(chainPEM)
+	if err != nil {
+		return livePaths, err
+	}
+
+	files := []struct {
+		name    string
+		path    string
+		content []byte
+		mode    int
+	}{
+		{"cert.pem", livePaths.Cert, leafPEM, 0644},
+		{"chain.pem", livePaths.Chain, intermediatesPEM, 0644},
+		{"fullchain.pem", livePaths.FullChain, chainPEM, 0644},
+		{"privkey.pem", livePaths.PrivateKey, keyPEM, 0600},
+	}
+
+	for _, dir := range []string{filepath.Dir(livePaths.Cert), archiveDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return livePaths, fmt.Errorf("creating directory [%v]: %v", dir, err)
+		}
+	}
+
+	for _, file := range files {
+		if _, err := WriteByteFile(archiveDir+file.name, file.content, file.mode, true); err != nil {
+			return livePaths, err
+		}
+		if _, err := WriteByteFile(file.path, file.content, file.mode, true); err != nil {
+			return livePaths, err
+		}
+	}
+
+	return livePaths, nil
+}
+
+// deployFile is a single file written to the SavePaths
+type deployFile struct {
+	path    string
+	content []byte
+	mode    int
+}
+
+// deploymentFiles assembles the files that should exist at the SavePaths in the configured SaveType format
+func deploymentFiles(cert Certificate, livePaths LiveCertificatePaths) ([]deployFile, error) {
+	if cert.SavePaths.Cert == "" {
+		return nil, nil
+	}
+
+	fullChainPEM, err := ReadFileToBytes(livePaths.FullChain)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := ReadFileToBytes(livePaths.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cert.SaveType {
+	case "haproxy":
+		// HAProxy wants the full chain and the key in a single file
+		combined := append(append([]byte{}, fullChainPEM...), keyPEM...)
+		return []deployFile{{cert.SavePaths.Cert, combined, 0600}}, nil
+	default:
+		files := []deployFile{{cert.SavePaths.Cert, fullChainPEM, 0644}}
+		if cert.SavePaths.Key != "" {
+			files = append(files, deployFile{cert.SavePaths.Key, keyPEM, 0600})
+		}
+		return files, nil
+	}
+}
+
+// DeployCertificate copies the live certificate to the configured SavePaths in the configured SaveType format
+func DeployCertificate(cert Certificate, livePaths LiveCertificatePaths) error {
+	files, err := deploymentFiles(cert, livePaths)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if err := os.MkdirAll(filepath.Dir(file.path), 0755); err != nil {
+			return fmt.Errorf("creating save path directory: %v", err)
+		}
+		if _, err := WriteByteFile(file.path, file.content, file.mode, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeploymentDrifted checks if any of the files at the SavePaths are missing or differ from the live store
+func DeploymentDrifted(cert Certificate, livePaths LiveCertificatePaths) (bool, error) {
+	files, err := deploymentFiles(cert, livePaths)
+	if err != nil {
+		return false, err
+	}
+
+	for _, file := range files {
+		exists, err := FileExists(file.path)
+		if err != nil {
+			return false, fmt.Errorf("checking for the save path file [%v]: %v", file.path, err)
+		}
+		if !exists {
+			return true, nil
+		}
+		deployed, err := ReadFileToBytes(file.path)
+		if err != nil {
+			return false, err
+		}
+		if !bytes.Equal(deployed, file.content) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// splitChainPEM splits a PEM chain into the leaf certificate and the remaining intermediates
+func splitChainPEM(chainPEM []byte) ([]byte, []byte, error) {
+	block, rest := pem.Decode(chainPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, nil, fmt.Errorf("failed to decode leaf certificate from chain")
+	}
+	return pem.EncodeToMemory(block), bytes.TrimLeft(rest, "\n"), nil
+}
@@ -0,0 +1,134 @@
+package roadrunner
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// NewConnectionInfo merges the global AppConfig defaults with the per-issuer settings
+// Anything set on the issuer takes precedence over the global configuration
+func NewConnectionInfo(appConfig AppConfig, issuer Issuer) ConnectionInfo {
+	cInfo := ConnectionInfo{
+		IssuerName:     issuer.Name,
+		DirectoryURL:   issuer.Endpoint,
+		SkipTLSVerify:  appConfig.SkipTLSVerify,
+		CAFile:         appConfig.CAFile,
+		ClientCertFile: issuer.ClientCertFile,
+		ClientKeyFile:  issuer.ClientKeyFile,
+		HTTPProxy:      appConfig.HTTPProxy,
+		HTTPSProxy:     appConfig.HTTPSProxy,
+		NoProxy:        appConfig.NoProxy,
+	}
+
+	// Per-issuer overrides
+	if issuer.SkipTLSVerify != nil {
+		cInfo.SkipTLSVerify = *issuer.SkipTLSVerify
+	}
+	if issuer.CAFile != "" {
+		cInfo.CAFile = issuer.CAFile
+	}
+	if issuer.HTTPProxy != "" {
+		cInfo.HTTPProxy = issuer.HTTPProxy
+	}
+	if issuer.HTTPSProxy != "" {
+		cInfo.HTTPSProxy = issuer.HTTPSProxy
+	}
+	if len(issuer.NoProxy) > 0 {
+		cInfo.NoProxy = issuer.NoProxy
+	}
+
+	return cInfo
+}
+
+// NewHTTPTransport is the shared factory for outbound HTTP transports
+// It applies the proxy/no-proxy rules and the TLS configuration from the ConnectionInfo
+func NewHTTPTransport(cInfo ConnectionInfo) (*http.Transport, error) {
+	tlsConfig, err := NewTLSConfig(cInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &http.Transport{
+		Proxy: NewProxyFunc(cInfo),
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       tlsConfig,
+	}
+
+	return transport, nil
+}
+
+// NewProxyFunc returns the proxy selection function for a transport
+// If no proxy is configured the standard HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment variables are used
+func NewProxyFunc(cInfo ConnectionInfo) func(*http.Request) (*url.URL, error) {
+	if cInfo.HTTPProxy == "" && cInfo.HTTPSProxy == "" {
+		return http.ProxyFromEnvironment
+	}
+
+	proxyConfig := &httpproxy.Config{
+		HTTPProxy:  cInfo.HTTPProxy,
+		HTTPSProxy: cInfo.HTTPSProxy,
+		NoProxy:    strings.Join(cInfo.NoProxy, ","),
+	}
+	proxyFunc := proxyConfig.ProxyFunc()
+
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
+	}
+}
+
+// NewTLSConfig assembles the TLS configuration used when connecting to an ACME server
+// The optional CA file is appended to the system roots, and an optional client certificate
+// and key can be provided for mutual TLS authentication against the endpoint
+func NewTLSConfig(cInfo ConnectionInfo) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cInfo.SkipTLSVerify,
+	}
+
+	// Load the CA bundle into a pool that includes the system roots
+	if cInfo.CAFile != "" {
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil || rootCAs == nil {
+			rootCAs = x509.NewCertPool()
+		}
+
+		caBytes, err := ReadFileToBytes(cInfo.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA file [%v]: %v", cInfo.CAFile, err)
+		}
+		if !rootCAs.AppendCertsFromPEM(caBytes) {
+			return nil, fmt.Errorf("no PEM certificates found in CA file [%v]", cInfo.CAFile)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	// Load the optional client certificate pair for mTLS
+	if cInfo.ClientCertFile != "" || cInfo.ClientKeyFile != "" {
+		if cInfo.ClientCertFile == "" || cInfo.ClientKeyFile == "" {
+			return nil, fmt.Errorf("both client_cert_file and client_key_file must be set for mTLS")
+		}
+		clientCert, err := tls.LoadX509KeyPair(cInfo.ClientCertFile, cInfo.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate pair [%v, %v]: %v", cInfo.ClientCertFile, cInfo.ClientKeyFile, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{clientCert}
+	}
+
+	return tlsConfig, nil
+}
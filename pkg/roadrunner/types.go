@@ -1,8 +1,12 @@
 package roadrunner
 
+import "time"
+
 // CLIOpts contains the CLI options
 type CLIOpts struct {
 	Config string
+	// LockWait is how long to wait for another process to release the working directory
+	LockWait time.Duration
 }
 
 // Config struct for webapp config at the top level
@@ -18,6 +22,8 @@ type Roadrunner struct {
 	Certificates []Certificate `yaml:"certificates"`
 	// Issuers is the list of issuers to use for generating and/or renewing certificates
 	Issuers []Issuer `yaml:"issuers"`
+	// Notifiers are told when certificates are issued, renewed, fail or are close to expiry
+	Notifiers []Notifier `yaml:"notifiers,omitempty"`
 }
 
 // Config is the structure that houses the general configuration
@@ -32,14 +38,47 @@ type AppConfig struct {
 	NoProxy []string `yaml:"no_proxy,omitempty"`
 	// SkipTLSVerify is a global flag to enable/disable SSL verification
 	SkipTLSVerify bool `yaml:"skip_tls_verify,omitempty"`
+	// CAFile is an optional global CA bundle that is appended to the system roots
+	CAFile string `yaml:"ca_file,omitempty"`
 	// WorkingDir is the directory to use for storing generated files
 	WorkingDir string `yaml:"working_dir,omitempty"`
+	// CheckInterval is how often the daemon checks the certificates for renewal, eg "12h"
+	CheckInterval time.Duration `yaml:"check_interval,omitempty"`
+	// CheckJitter is the maximum random delay added to each check interval so a fleet doesn't stampede the CA
+	CheckJitter time.Duration `yaml:"check_jitter,omitempty"`
+	// RetryBackoffBase is the delay after the first failed attempt at a certificate, doubling on each failure
+	RetryBackoffBase time.Duration `yaml:"retry_backoff_base,omitempty"`
+	// RetryBackoffMax caps the delay between failed attempts at a certificate
+	RetryBackoffMax time.Duration `yaml:"retry_backoff_max,omitempty"`
+	// DeployHookMode is "batch" to run each identical deploy hook once at the end of a run,
+	// or "immediate" to run them straight after each certificate is deployed, defaults to "batch"
+	DeployHookMode string `yaml:"deploy_hook_mode,omitempty"`
+	// Workers is how many certificates are processed at the same time, defaults to 4
+	Workers int `yaml:"workers,omitempty"`
+	// HTTP01Listen is the address the http-01 challenge server binds, defaults to ":80"
+	// A systemd socket activated listener named "http-01" is used instead when one is passed
+	HTTP01Listen string `yaml:"http01_listen,omitempty"`
+	// MetricsListen is the optional address to serve Prometheus metrics on at /metrics in daemon mode, eg ":9101"
+	MetricsListen string `yaml:"metrics_listen,omitempty"`
+	// APIListen is the optional address to serve the read-only status API on in daemon mode, eg "127.0.0.1:9102"
+	// Serves /healthz, /readyz and /v1/certificates, and /metrics too when MetricsListen is the same address
+	APIListen string `yaml:"api_listen,omitempty"`
+	// APIAdminToken is the bearer token that authorizes the admin endpoints of the API
+	APIAdminToken string `yaml:"api_admin_token,omitempty"`
+	// APITLSCertFile and APITLSKeyFile serve the API over TLS when both are set
+	APITLSCertFile string `yaml:"api_tls_cert_file,omitempty"`
+	APITLSKeyFile  string `yaml:"api_tls_key_file,omitempty"`
+	// APIClientCAFile authorizes the admin endpoints for client certificates signed by this CA
+	APIClientCAFile string `yaml:"api_client_ca_file,omitempty"`
 }
 
 // Certificate is the struct for the ssl certificate to generate/renew
 type Certificate struct {
-	// Issuer is the name of the ACME solver as an Issuer
-	Issuer string `yaml:"issuer"`
+	// Name is the stable identity of the certificate, used for its directories in the working directory,
+	// metrics and logs, and defaults to one derived from the first domain, eg *.example.com is wildcard.example.com
+	Name string `yaml:"name,omitempty"`
+	// Issuer is the name of the ACME solver as an Issuer, or an ordered list of Issuers to fall back through
+	Issuer IssuerRefs `yaml:"issuer"`
 	// Email is the email address used when registering with the ACME endpoint
 	Email string `yaml:"email"`
 	// Domains is a list of domains to generate a certificate for
@@ -50,12 +89,70 @@ type Certificate struct {
 	// If the directory does not exist, it will be created
 	// The files saved will be named after the domain name and .crt and optionally the .key file as well if using pem-pair
 	SavePaths SavePaths `yaml:"save_paths,omitempty"`
-	// RestartCmd is the command that will be run after the certificate is generated or renewed
+	// PreHook are the commands run before the certificate is requested
+	PreHook []Hook `yaml:"pre_hook,omitempty"`
+	// DeployHook are the commands run after the certificate is deployed, eg to reload the services using it
+	DeployHook []Hook `yaml:"deploy_hook,omitempty"`
+	// PostHook are the commands run after every attempt to request the certificate, successful or not
+	PostHook []Hook `yaml:"post_hook,omitempty"`
+	// RestartCmd is deprecated, it is run through the shell as the last deploy_hook
 	RestartCmd string `yaml:"restart_cmd,omitempty"`
-	// RenewDays is the number of days before the certificate expires that it will be renewed
+	// RenewDays is the number of days before the certificate expires that it will be renewed, defaults to 30
+	// Certificates with a shorter lifetime are renewed once a third of their lifetime remains
 	RenewDays int `yaml:"renew_days,omitempty"`
 	// RequestOptions is the list of options that are used when requesting the certificate
 	RequestOptions RequestOptions `yaml:"request_options,omitempty"`
+	// PreferredChain is the issuer Common Name of the topmost certificate in the preferred alternate chain
+	// Overrides the issuer setting, falling back to the default chain if no match is offered
+	PreferredChain string `yaml:"preferred_chain,omitempty"`
+}
+
+// IssuerRefs is an ordered list of Issuer references, tried in order until one succeeds
+type IssuerRefs []IssuerRef
+
+// IssuerRef references an Issuer by name along with the retry budget for that Issuer
+type IssuerRef struct {
+	// Name is the name of the Issuer
+	Name string `yaml:"name"`
+	// Retries is the number of times to retry the Issuer before falling back to the next one
+	Retries int `yaml:"retries,omitempty"`
+}
+
+// UnmarshalYAML allows the issuer to be a single name or a list of names and/or IssuerRef objects
+func (refs *IssuerRefs) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	if err := unmarshal(&name); err == nil {
+		*refs = IssuerRefs{{Name: name}}
+		return nil
+	}
+
+	var list []IssuerRef
+	if err := unmarshal(&list); err != nil {
+		return err
+	}
+	*refs = list
+	return nil
+}
+
+// UnmarshalYAML allows an IssuerRef to be a plain Issuer name
+func (ref *IssuerRef) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	if err := unmarshal(&name); err == nil {
+		*ref = IssuerRef{Name: name}
+		return nil
+	}
+
+	type plain IssuerRef
+	return unmarshal((*plain)(ref))
+}
+
+// Names returns the names of the referenced Issuers in order
+func (refs IssuerRefs) Names() []string {
+	names := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		names = append(names, ref.Name)
+	}
+	return names
 }
 
 // SavePaths is a grouping of the possible assets saved by the application
@@ -70,22 +167,41 @@ type SavePaths struct {
 type Issuer struct {
 	// Name is the name of the solver to use
 	Name string `yaml:"name"`
-	// Type is the type of solver to use, options are "none", "http" and "dns-01"
+	// Type is the type of solver to use, options are "none", "http-01" and "dns-01"
 	Type string `yaml:"type"`
 	// Endpoint is the endpoint URL for the solver directory
 	Endpoint string `yaml:"endpoint"`
-	// CAFile is an optional path to a CA file to use for the solver
+	// CAFile is an optional path to a CA file to use for the solver, appended to the system roots
 	CAFile string `yaml:"ca_file,omitempty"`
-	// SkipTLSVerify is a flag to enable/disable SSL verification
-	SkipTLSVerify bool `yaml:"skip_tls_verify,omitempty"`
+	// ClientCertFile is an optional path to a client certificate used for mTLS with the endpoint
+	ClientCertFile string `yaml:"client_cert_file,omitempty"`
+	// ClientKeyFile is an optional path to the private key for the mTLS client certificate
+	ClientKeyFile string `yaml:"client_key_file,omitempty"`
+	// SkipTLSVerify is a flag to enable/disable SSL verification, overrides the global setting when set
+	SkipTLSVerify *bool `yaml:"skip_tls_verify,omitempty"`
+	// HTTPProxy is the HTTP proxy to use for this issuer, overrides the global setting when set
+	HTTPProxy string `yaml:"http_proxy,omitempty"`
+	// HTTPSProxy is the HTTPS proxy to use for this issuer, overrides the global setting when set
+	HTTPSProxy string `yaml:"https_proxy,omitempty"`
+	// NoProxy is the list of domains to not use the proxy for, overrides the global setting when set
+	NoProxy []string `yaml:"no_proxy,omitempty"`
+	// PreferredChain is the issuer Common Name of the topmost certificate in the preferred alternate chain
+	PreferredChain string `yaml:"preferred_chain,omitempty"`
+	// MaxConcurrency is how many orders may run against the issuer at the same time, unlimited when unset
+	MaxConcurrency int `yaml:"max_concurrency,omitempty"`
 }
 
 // RequestOptions is the struct for the options used when requesting the certificate
 type RequestOptions struct {
-	// KeyType is the type of key to use, options are "rsa" and "ecdsa"
+	// KeyType is the type of key to use, options are "rsa" and "ecdsa", defaults to "ecdsa"
 	KeyType string `yaml:"key_type,omitempty"`
-	// KeySize is the size of the key to use, options are 2048, 4096, and 8192
+	// KeySize is the size of the key to use, options are 2048, 3072, 4096 and 8192 for rsa, defaulting to 2048,
+	// and 256, 384 and 521 for ecdsa, defaulting to 256
 	KeySize int `yaml:"key_size,omitempty"`
-	// Expiration is the number of days the certificate will be valid for
+	// KeyTypes requests one certificate per key type, eg ["ecdsa", "rsa:4096"], in place of key_type and key_size
+	// The certificates share the domain authorizations and are deployed with the key type appended to save_paths
+	KeyTypes []string `yaml:"key_types,omitempty"`
+	// Expiration is the number of days the certificate will be valid for, sent as the order notBefore/notAfter
+	// The CA may shorten this within its own limits, which is logged as a warning
 	Expiration int `yaml:"expiration,omitempty"`
 }
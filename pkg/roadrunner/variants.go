@@ -0,0 +1,77 @@
+package roadrunner
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseKeyTypeEntry parses a key_types entry such as "rsa" or "ecdsa:384" into RequestOptions
+func ParseKeyTypeEntry(entry string, opts RequestOptions) (RequestOptions, error) {
+	keyType, keySize, hasSize := strings.Cut(strings.TrimSpace(entry), ":")
+	opts.KeyType = keyType
+	opts.KeySize = 0
+	opts.KeyTypes = nil
+	if hasSize {
+		size, err := strconv.Atoi(keySize)
+		if err != nil {
+			return opts, fmt.Errorf("invalid key size in key_types entry [%v]", entry)
+		}
+		opts.KeySize = size
+	}
+	return opts, ValidateKeySpec(opts)
+}
+
+// ExpandKeyTypes turns every certificate that requests several key_types into one certificate per key type
+// Each variant is named <name>.<key type> and deployed with the key type appended to its save_paths,
+// eg site.pem.ecdsa and site.pem.rsa, which HAProxy loads as a single multi-certificate bundle
+// Variants are kept next to each other so the later orders reuse the authorizations the first one validated
+func ExpandKeyTypes(certs []Certificate) ([]Certificate, error) {
+	expanded := []Certificate{}
+	for i, cert := range certs {
+		keyTypes := cert.RequestOptions.KeyTypes
+		if len(keyTypes) == 0 || len(cert.Domains) == 0 {
+			expanded = append(expanded, cert)
+			continue
+		}
+		if cert.RequestOptions.KeyType != "" || cert.RequestOptions.KeySize != 0 {
+			return nil, fmt.Errorf("certificate %d sets key_types along with key_type or key_size", i+1)
+		}
+
+		// A single key type needs no suffixes
+		if len(keyTypes) == 1 {
+			opts, err := ParseKeyTypeEntry(keyTypes[0], cert.RequestOptions)
+			if err != nil {
+				return nil, fmt.Errorf("certificate [%v]: %v", cert.ID(), err)
+			}
+			cert.RequestOptions = opts
+			expanded = append(expanded, cert)
+			continue
+		}
+
+		seen := map[string]bool{}
+		for _, entry := range keyTypes {
+			opts, err := ParseKeyTypeEntry(entry, cert.RequestOptions)
+			if err != nil {
+				return nil, fmt.Errorf("certificate [%v]: %v", cert.ID(), err)
+			}
+			keyType, _ := KeySpec(opts)
+			if seen[keyType] {
+				return nil, fmt.Errorf("certificate [%v] requests key type %v more than once", cert.ID(), keyType)
+			}
+			seen[keyType] = true
+
+			variant := cert
+			variant.Name = cert.ID() + "." + keyType
+			variant.RequestOptions = opts
+			if variant.SavePaths.Cert != "" {
+				variant.SavePaths.Cert += "." + keyType
+			}
+			if variant.SavePaths.Key != "" {
+				variant.SavePaths.Key += "." + keyType
+			}
+			expanded = append(expanded, variant)
+		}
+	}
+	return expanded, nil
+}
@@ -0,0 +1,96 @@
+package roadrunner
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/kenmoini/roadrunner/internal/logging"
+)
+
+// watchedFiles returns the absolute paths of the files the daemon watches for changes
+// mapped to the certificate they belong to, the configuration file maps to nil
+func (d *Daemon) watchedFiles() map[string]*Certificate {
+	files := map[string]*Certificate{}
+
+	if configPath, err := filepath.Abs(d.opts.Config); err == nil {
+		files[configPath] = nil
+	}
+
+	config := d.Config()
+	for i := range config.Roadrunner.Certificates {
+		cert := &config.Roadrunner.Certificates[i]
+		for _, path := range []string{cert.SavePaths.Cert, cert.SavePaths.Key} {
+			if path == "" {
+				continue
+			}
+			if absPath, err := filepath.Abs(path); err == nil {
+				files[absPath] = cert
+			}
+		}
+	}
+
+	return files
+}
+
+// handleChangedFiles reloads the configuration or re-deploys certificates for a batch of changed files
+func (d *Daemon) handleChangedFiles(ctx context.Context, changed map[string]bool) {
+	files := d.watchedFiles()
+	redeploy := map[*Certificate]bool{}
+
+	for path := range changed {
+		cert, watched := files[path]
+		if !watched {
+			continue
+		}
+		if cert == nil {
+			logging.LogStdOutInfo(fmt.Sprintf("Configuration file [%v] changed, reloading configuration...", path))
+			d.RequestReload()
+			continue
+		}
+		redeploy[cert] = true
+	}
+
+	config := d.Config()
+	for cert := range redeploy {
+		config.redeployCertificate(ctx, *cert)
+	}
+}
+
+// redeployCertificate re-deploys a certificate from the live store if its deployed files drifted
+func (config Config) redeployCertificate(ctx context.Context, cert Certificate) {
+	// Leave the certificate alone while the scheduler or an admin job is working on it
+	unlock, err := CertLocks.Lock(ctx, cert.ID())
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	livePaths := NewLiveCertificatePaths(config.Roadrunner.Config.WorkingDir, cert.ID())
+	liveCert, err := LoadLiveCertificate(livePaths)
+	if err != nil || liveCert == nil {
+		return
+	}
+
+	if cert.SaveType == "" {
+		cert.SaveType = DefaultSaveType
+	}
+	drifted, err := DeploymentDrifted(cert, livePaths)
+	if err != nil {
+		logging.Check(err, fmt.Sprintf("[%v] Failed to check the deployed certificate", cert.ID()))
+		return
+	}
+	if !drifted {
+		return
+	}
+
+	logPrefix := fmt.Sprintf("[%v]", cert.ID())
+	logging.LogStdOutWarn(logPrefix + " Deployed certificate was deleted or modified, re-deploying it from the live store...")
+	if err := DeployCertificate(cert, livePaths); err != nil {
+		logging.Check(err, logPrefix+" Failed to re-deploy the certificate")
+		return
+	}
+	if err := config.runDeployHooks(ctx, logPrefix, cert, livePaths, false); err != nil {
+		logging.Check(err, logPrefix+" Failed to run the deploy hooks")
+	}
+}
@@ -0,0 +1,92 @@
+//go:build linux
+
+package roadrunner
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"github.com/kenmoini/roadrunner/internal/logging"
+	"golang.org/x/sys/unix"
+)
+
+// watchEvents are the inotify events that indicate a watched file was changed, replaced or removed
+const watchEvents = unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_MOVED_FROM | unix.IN_DELETE | unix.IN_CREATE | unix.IN_ATTRIB
+
+// watchPollTimeout is how long to wait for more events before handling a batch, in milliseconds
+const watchPollTimeout = 500
+
+// WatchFiles watches the configuration file and the deployed SavePaths with inotify until the context is cancelled
+// The parent directories are watched so files replaced by editors or deploy tools are still picked up
+func (d *Daemon) WatchFiles(ctx context.Context) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		logging.LogStdOutWarn(fmt.Sprintf("Failed to start the file watcher: %v", err))
+		return
+	}
+	defer unix.Close(fd)
+
+	// watches maps the inotify watch descriptors to the watched directories
+	watches := map[int]string{}
+	watchedDirs := map[string]bool{}
+	changed := map[string]bool{}
+	buf := make([]byte, (unix.SizeofInotifyEvent+unix.PathMax)*16)
+
+	for ctx.Err() == nil {
+		// Add any directories that are new or have been created since the last pass
+		for path := range d.watchedFiles() {
+			dir := filepath.Dir(path)
+			if watchedDirs[dir] {
+				continue
+			}
+			wd, err := unix.InotifyAddWatch(fd, dir, watchEvents)
+			if err != nil {
+				continue
+			}
+			watches[wd] = dir
+			watchedDirs[dir] = true
+		}
+
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+		n, err := unix.Poll(fds, watchPollTimeout)
+		if err != nil && err != unix.EINTR {
+			logging.LogStdOutWarn(fmt.Sprintf("File watcher stopped: %v", err))
+			return
+		}
+
+		// Handle the batch once things have gone quiet
+		if n <= 0 {
+			if len(changed) > 0 {
+				d.handleChangedFiles(ctx, changed)
+				changed = map[string]bool{}
+			}
+			continue
+		}
+
+		read, err := unix.Read(fd, buf)
+		if err != nil || read < unix.SizeofInotifyEvent {
+			continue
+		}
+
+		for offset := 0; offset+unix.SizeofInotifyEvent <= read; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + unix.SizeofInotifyEvent
+			name := strings.TrimRight(string(buf[nameStart:nameStart+int(event.Len)]), "\x00")
+			offset = nameStart + int(event.Len)
+
+			// The watched directory itself went away, watch it again once it's back
+			if event.Mask&unix.IN_IGNORED != 0 {
+				delete(watchedDirs, watches[int(event.Wd)])
+				delete(watches, int(event.Wd))
+				continue
+			}
+
+			if dir, ok := watches[int(event.Wd)]; ok && name != "" {
+				changed[filepath.Join(dir, name)] = true
+			}
+		}
+	}
+}
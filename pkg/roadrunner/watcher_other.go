@@ -0,0 +1,14 @@
+//go:build !linux
+
+package roadrunner
+
+import (
+	"context"
+
+	"github.com/kenmoini/roadrunner/internal/logging"
+)
+
+// WatchFiles is only supported on Linux, elsewhere use SIGHUP to reload the configuration
+func (d *Daemon) WatchFiles(ctx context.Context) {
+	logging.LogStdOutWarn("File watching is only supported on Linux, use SIGHUP to reload the configuration")
+}